@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alex/smtp-gotify/internal/admin"
+	"github.com/alex/smtp-gotify/internal/config"
+	"github.com/alex/smtp-gotify/internal/spool"
+)
+
+const deadLetterUsage = `usage: smtp-gotify deadletter <command>
+
+commands:
+  list                  list dead letters
+  show <id>             print a dead letter as JSON
+  replay <id> [route]   queue a dead letter again, optionally for another route
+                        or destination
+  purge <id>|--all      delete one or all dead letters`
+
+// runDeadLetter implements the deadletter subcommand and returns the exit
+// code. It reads the same environment as the server.
+func runDeadLetter(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, deadLetterUsage)
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to load config:", err)
+		return 1
+	}
+	if cfg.Spool.Dir == "" {
+		fmt.Fprintln(os.Stderr, "SPOOL_DIR is not set")
+		return 1
+	}
+
+	deadLetters, err := spool.OpenDeadLetters(cfg.Spool.Dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		err = listDeadLetters(deadLetters)
+	case args[0] == "show" && len(args) == 2:
+		err = showDeadLetter(deadLetters, args[1])
+	case args[0] == "replay" && (len(args) == 2 || len(args) == 3):
+		var route string
+		if len(args) == 3 {
+			route = args[2]
+			if !hasRouteConfig(cfg, route) {
+				err = fmt.Errorf("unknown route %s", route)
+				break
+			}
+		}
+		err = deadLetters.Replay(args[1], route)
+	case args[0] == "purge" && len(args) == 2 && args[1] == "--all":
+		var n int
+		n, err = deadLetters.PurgeAll()
+		fmt.Printf("purged %d dead letters\n", n)
+	case args[0] == "purge" && len(args) == 2:
+		err = deadLetters.Purge(args[1])
+	default:
+		fmt.Fprintln(os.Stderr, deadLetterUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if errors.Is(err, spool.ErrNotFound) {
+			return 3
+		}
+		return 1
+	}
+	return 0
+}
+
+func listDeadLetters(deadLetters *spool.DeadLetters) error {
+	entries, err := deadLetters.List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tFAILED\tATTEMPTS\tSUBJECT\tREASON")
+	for _, e := range entries {
+		s := admin.NewSummary(e)
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", s.ID, s.Failed.Format(time.RFC3339), s.Attempts, s.Subject, strings.ReplaceAll(s.Reason, "\n", "; "))
+	}
+	return w.Flush()
+}
+
+func showDeadLetter(deadLetters *spool.DeadLetters, id string) error {
+	e, err := deadLetters.Get(id)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(e)
+}
+
+func hasRouteConfig(cfg *config.Config, name string) bool {
+	if name == "default" && cfg.Gotify.URL != "" {
+		return true
+	}
+	for _, d := range cfg.Gotify.Destinations {
+		if d.Name == name {
+			return true
+		}
+	}
+	for _, w := range cfg.Webhooks {
+		if w.Name == name {
+			return true
+		}
+	}
+	for _, n := range cfg.Ntfy {
+		if n.Name == name {
+			return true
+		}
+	}
+	for _, r := range cfg.Gotify.Users {
+		if r.Name == name {
+			return true
+		}
+	}
+	for _, r := range cfg.Gotify.Recipients {
+		if strings.EqualFold(r.Name, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -7,14 +7,23 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/alex/smtp-gotify/internal/admin"
 	"github.com/alex/smtp-gotify/internal/config"
+	"github.com/alex/smtp-gotify/internal/delivery"
 	"github.com/alex/smtp-gotify/internal/gotify"
 	"github.com/alex/smtp-gotify/internal/health"
 	"github.com/alex/smtp-gotify/internal/mail"
+	"github.com/alex/smtp-gotify/internal/ntfy"
 	"github.com/alex/smtp-gotify/internal/smtp"
+	"github.com/alex/smtp-gotify/internal/spool"
 	"github.com/alex/smtp-gotify/internal/template"
+	"github.com/alex/smtp-gotify/internal/webhook"
 )
 
 func main() {
@@ -33,6 +42,10 @@ func main() {
 		os.Exit(0)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "deadletter" {
+		os.Exit(runDeadLetter(os.Args[2:]))
+	}
+
 	cfg, err := config.Load()
 	if err != nil {
 		slog.Error("failed to load config", "error", err)
@@ -47,22 +60,134 @@ func main() {
 		os.Exit(1)
 	}
 
+	userRoutes, err := buildRoutes(cfg.Gotify.Users)
+	if err != nil {
+		logger.Error("failed to create user routes", "error", err)
+		os.Exit(1)
+	}
+
+	recipientRoutes, err := buildRoutes(cfg.Gotify.Recipients)
+	if err != nil {
+		logger.Error("failed to create recipient routes", "error", err)
+		os.Exit(1)
+	}
+
 	parser := mail.NewParser()
 
-	gotifyClient := gotify.NewClient(gotify.Config{
-		URL:      cfg.Gotify.URL,
-		Tokens:   cfg.Gotify.Tokens,
-		Priority: cfg.Gotify.Priority,
-		Markdown: cfg.Gotify.Markdown,
-		Renderer: renderer,
-		Logger:   logger,
-	})
+	// Background work of the destinations, such as breaker probes, ends
+	// with the server
+	destCtx, stopDestinations := context.WithCancel(context.Background())
+	defer stopDestinations()
+
+	destinations, err := buildDestinations(destCtx, cfg.Gotify.Destinations, logger)
+	if err != nil {
+		logger.Error("failed to create destinations", "error", err)
+		os.Exit(1)
+	}
+
+	if err := buildWebhooks(destinations, cfg.Webhooks, logger); err != nil {
+		logger.Error("failed to create webhooks", "error", err)
+		os.Exit(1)
+	}
 
-	smtpServer := smtp.NewServer(cfg.SMTP, logger, parser, gotifyClient)
+	if err := buildNtfy(destinations, cfg.Ntfy, logger); err != nil {
+		logger.Error("failed to create ntfy destinations", "error", err)
+		os.Exit(1)
+	}
+
+	if cfg.Gotify.URL != "" {
+		transport, err := newTransport(cfg.Gotify.HTTP, "default", logger)
+		if err != nil {
+			logger.Error("failed to create Gotify transport", "error", err)
+			os.Exit(1)
+		}
+		correlation, err := buildCorrelation(cfg.Gotify.Correlation)
+		if err != nil {
+			logger.Error("failed to create alert correlation", "error", err)
+			os.Exit(1)
+		}
+		provision, err := buildProvision(cfg.Gotify.Provision)
+		if err != nil {
+			logger.Error("failed to set up application provisioning", "error", err)
+			os.Exit(1)
+		}
+		client := gotify.NewClient(gotify.Config{
+			URL:             cfg.Gotify.URL,
+			Tokens:          cfg.Gotify.Tokens,
+			Priority:        cfg.Gotify.Priority,
+			Markdown:        cfg.Gotify.Markdown,
+			Renderer:        renderer,
+			Routes:          userRoutes,
+			RecipientRoutes: recipientRoutes,
+			Retries:         cfg.Gotify.Retries,
+			RetryMin:        cfg.Gotify.RetryMin,
+			RetryMax:        cfg.Gotify.RetryMax,
+			Timeout:         cfg.Gotify.Timeout,
+			Concurrency:     cfg.Gotify.Concurrency,
+			Transport:       transport,
+			Limit:           gotify.Limit{MaxSize: cfg.Gotify.MaxMessageSize, Strategy: cfg.Gotify.OversizeStrategy, MaxParts: cfg.Gotify.MaxParts},
+			ClientToken:     cfg.Gotify.ClientToken,
+			Correlation:     correlation,
+			Provision:       provision,
+			Logger:          logger,
+		})
+		destinations["default"] = withBreaker(destCtx, "default", client, cfg.Gotify.BreakerThreshold, cfg.Gotify.BreakerCooldown, logger)
+	}
+
+	router := delivery.NewRouter(
+		destinations,
+		cfg.Gotify.DefaultDestinations,
+		routeDestinations(cfg.Gotify.Users),
+		routeDestinations(cfg.Gotify.Recipients),
+	)
+
+	var forwarder smtp.Forwarder = router
+
+	spoolCtx, stopSpool := context.WithCancel(context.Background())
+	spoolDone := make(chan struct{})
+	var deadLetters *spool.DeadLetters
+	if cfg.Spool.Dir != "" {
+		queue, err := spool.New(spool.Config{
+			Dir:         cfg.Spool.Dir,
+			Forwarder:   router,
+			MinBackoff:  cfg.Spool.MinBackoff,
+			MaxBackoff:  cfg.Spool.MaxBackoff,
+			MaxAttempts: cfg.Spool.MaxAttempts,
+			Timeout:     cfg.SMTP.DeliveryTimeout,
+			Workers:     cfg.Spool.Workers,
+			Logger:      logger,
+		})
+		if err != nil {
+			logger.Error("failed to open spool", "error", err)
+			os.Exit(1)
+		}
+		forwarder = queue
+		deadLetters = queue.DeadLetters()
+		go func() {
+			queue.Run(spoolCtx)
+			close(spoolDone)
+		}()
+	} else {
+		close(spoolDone)
+	}
+
+	smtpServer, err := smtp.NewServer(cfg.SMTP, logger, parser, forwarder)
+	if err != nil {
+		logger.Error("failed to create SMTP server", "error", err)
+		os.Exit(1)
+	}
 
 	var healthServer *health.Server
 	if cfg.Health.Enabled {
 		healthServer = health.NewServer(cfg.Health.Listen, logger)
+		for name, d := range destinations {
+			if b, ok := d.(*delivery.Breaker); ok {
+				healthServer.AddDestination(name, b)
+			}
+		}
+		if cfg.Health.AdminToken != "" && deadLetters != nil {
+			healthServer.Handle("/admin/", admin.NewHandler(cfg.Health.AdminToken, deadLetters, router.HasRoute, logger))
+		}
 		go func() {
 			if err := healthServer.ListenAndServe(); err != nil {
 				logger.Error("health server error", "error", err)
@@ -89,9 +214,217 @@ func main() {
 			logger.Error("health server shutdown error", "error", err)
 		}
 	}
-	if err := smtpServer.Close(); err != nil {
-		logger.Error("SMTP server close error", "error", err)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.SMTP.ShutdownGrace)
+	defer cancel()
+	if err := smtpServer.Shutdown(ctx); err != nil {
+		logger.Error("SMTP server shutdown error", "error", err)
+	}
+
+	// Undelivered messages stay on disk for the next start
+	stopSpool()
+	<-spoolDone
+	stopDestinations()
+}
+
+func buildRoutes(cfgs []config.RouteConfig) (map[string]gotify.Route, error) {
+	routes := make(map[string]gotify.Route, len(cfgs))
+	for _, r := range cfgs {
+		renderer, err := template.NewRenderer(r.TitleTemplate, r.MessageTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", r.Name, err)
+		}
+		routes[r.Name] = gotify.Route{
+			Tokens:   r.Tokens,
+			Priority: r.Priority,
+			Renderer: renderer,
+		}
+	}
+	return routes, nil
+}
+
+func buildDestinations(ctx context.Context, cfgs []config.DestinationConfig, logger *slog.Logger) (map[string]delivery.Forwarder, error) {
+	destinations := make(map[string]delivery.Forwarder, len(cfgs)+1)
+	for _, d := range cfgs {
+		renderer, err := template.NewRenderer(d.TitleTemplate, d.MessageTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("destination %s: %w", d.Name, err)
+		}
+		transport, err := newTransport(d.HTTP, d.Name, logger)
+		if err != nil {
+			return nil, fmt.Errorf("destination %s: %w", d.Name, err)
+		}
+		correlation, err := buildCorrelation(d.Correlation)
+		if err != nil {
+			return nil, fmt.Errorf("destination %s: %w", d.Name, err)
+		}
+		provision, err := buildProvision(d.Provision)
+		if err != nil {
+			return nil, fmt.Errorf("destination %s: %w", d.Name, err)
+		}
+		client := gotify.NewClient(gotify.Config{
+			URL:         d.URL,
+			Tokens:      d.Tokens,
+			Priority:    d.Priority,
+			Markdown:    d.Markdown,
+			Renderer:    renderer,
+			Retries:     d.Retries,
+			RetryMin:    d.RetryMin,
+			RetryMax:    d.RetryMax,
+			Timeout:     d.Timeout,
+			Concurrency: d.Concurrency,
+			Transport:   transport,
+			Limit:       gotify.Limit{MaxSize: d.MaxMessageSize, Strategy: d.OversizeStrategy, MaxParts: d.MaxParts},
+			ClientToken: d.ClientToken,
+			Correlation: correlation,
+			Provision:   provision,
+			Logger:      logger.With("destination", d.Name),
+		})
+		destinations[d.Name] = withBreaker(ctx, d.Name, client, d.BreakerThreshold, d.BreakerCooldown, logger)
+	}
+	return destinations, nil
+}
+
+func buildWebhooks(destinations map[string]delivery.Forwarder, cfgs []config.WebhookConfig, logger *slog.Logger) error {
+	for _, w := range cfgs {
+		var body *template.Text
+		if w.BodyTemplate != "" {
+			var err error
+			if body, err = template.NewText("body", w.BodyTemplate); err != nil {
+				return fmt.Errorf("webhook %s: %w", w.Name, err)
+			}
+		}
+		header := make(http.Header)
+		for _, line := range w.Headers {
+			name, value, _ := strings.Cut(line, ":")
+			header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+		client, err := webhook.NewClient(webhook.Config{
+			URL:         w.URL,
+			Method:      w.Method,
+			Header:      header,
+			BearerToken: w.BearerToken,
+			Username:    w.Username,
+			Password:    w.Password,
+			Body:        body,
+			Timeout:     w.Timeout,
+			Logger:      logger.With("destination", w.Name),
+		})
+		if err != nil {
+			return fmt.Errorf("webhook %s: %w", w.Name, err)
+		}
+		destinations[w.Name] = client
+	}
+	return nil
+}
+
+func buildNtfy(destinations map[string]delivery.Forwarder, cfgs []config.NtfyConfig, logger *slog.Logger) error {
+	for _, n := range cfgs {
+		renderer, err := template.NewRenderer(n.TitleTemplate, n.MessageTemplate)
+		if err != nil {
+			return fmt.Errorf("ntfy %s: %w", n.Name, err)
+		}
+		var click, attach *template.Text
+		if n.ClickTemplate != "" {
+			if click, err = template.NewText("click", n.ClickTemplate); err != nil {
+				return fmt.Errorf("ntfy %s: %w", n.Name, err)
+			}
+		}
+		if n.AttachTemplate != "" {
+			if attach, err = template.NewText("attach", n.AttachTemplate); err != nil {
+				return fmt.Errorf("ntfy %s: %w", n.Name, err)
+			}
+		}
+		destinations[n.Name] = ntfy.NewClient(ntfy.Config{
+			URL:      n.URL,
+			Topics:   n.Topics,
+			Token:    n.Token,
+			Username: n.Username,
+			Password: n.Password,
+			Priority: n.Priority,
+			Tags:     n.Tags,
+			Click:    click,
+			Attach:   attach,
+			Markdown: n.Markdown,
+			Renderer: renderer,
+			Timeout:  n.Timeout,
+			Logger:   logger.With("destination", n.Name),
+		})
+	}
+	return nil
+}
+
+// withBreaker wraps client in a circuit breaker unless threshold is 0.
+func withBreaker(ctx context.Context, name string, client *gotify.Client, threshold int, cooldown time.Duration, logger *slog.Logger) delivery.Forwarder {
+	if threshold == 0 {
+		return client
+	}
+	return delivery.NewBreaker(ctx, name, client, threshold, cooldown, client.Probe, logger)
+}
+
+func newTransport(h config.HTTPConfig, name string, logger *slog.Logger) (*http.Transport, error) {
+	if h.InsecureSkipVerify {
+		logger.Warn("TLS certificate verification disabled", "destination", name)
+	}
+	return gotify.NewTransport(gotify.TransportConfig{
+		CAFile:              h.CAFile,
+		ClientCert:          h.ClientCert,
+		ClientKey:           h.ClientKey,
+		InsecureSkipVerify:  h.InsecureSkipVerify,
+		Proxy:               h.Proxy,
+		MaxIdleConns:        h.MaxIdleConns,
+		IdleConnTimeout:     h.IdleConnTimeout,
+		DialTimeout:         h.DialTimeout,
+		TLSHandshakeTimeout: h.TLSHandshakeTimeout,
+	})
+}
+
+func buildCorrelation(c config.CorrelationConfig) (gotify.Correlation, error) {
+	if c.Key == "" {
+		return gotify.Correlation{}, nil
+	}
+	key, err := template.NewKey(c.Key)
+	if err != nil {
+		return gotify.Correlation{}, fmt.Errorf("correlation key: %w", err)
+	}
+	resolved, err := regexp.Compile(c.ResolvedPattern)
+	if err != nil {
+		return gotify.Correlation{}, fmt.Errorf("resolved pattern: %w", err)
+	}
+	return gotify.Correlation{
+		Key:              key,
+		Resolved:         resolved,
+		ResolvedNote:     c.ResolvedNote,
+		ResolvedPriority: c.ResolvedPriority,
+		Supersede:        c.Supersede,
+		TTL:              c.TTL,
+	}, nil
+}
+
+func buildProvision(p config.ProvisionConfig) (gotify.Provision, error) {
+	provision := gotify.Provision{
+		By:          p.By,
+		Allow:       p.Allow,
+		MaxApps:     p.MaxApps,
+		NamePrefix:  p.NamePrefix,
+		Description: p.Description,
+	}
+	if p.By != "" && p.Icon != "" {
+		icon, err := os.ReadFile(p.Icon)
+		if err != nil {
+			return gotify.Provision{}, fmt.Errorf("read application icon: %w", err)
+		}
+		provision.Icon = icon
+		provision.IconName = filepath.Base(p.Icon)
+	}
+	return provision, nil
+}
+
+func routeDestinations(cfgs []config.RouteConfig) map[string][]string {
+	routes := make(map[string][]string, len(cfgs))
+	for _, r := range cfgs {
+		routes[r.Name] = r.Destinations
 	}
+	return routes
 }
 
 func setupLogger(cfg config.LogConfig) *slog.Logger {
@@ -0,0 +1,154 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alex/smtp-gotify/internal/spool"
+)
+
+// Summary is the list view of a dead letter.
+type Summary struct {
+	ID       string    `json:"id"`
+	Queued   time.Time `json:"queued"`
+	Failed   time.Time `json:"failed"`
+	Attempts int       `json:"attempts"`
+	Reason   string    `json:"reason"`
+	From     string    `json:"from"`
+	Subject  string    `json:"subject"`
+}
+
+func NewSummary(e *spool.Entry) Summary {
+	return Summary{
+		ID:       e.ID,
+		Queued:   e.Queued,
+		Failed:   e.Failed,
+		Attempts: e.Attempts,
+		Reason:   e.LastError,
+		From:     e.Message.From,
+		Subject:  e.Message.Subject,
+	}
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type Handler struct {
+	mux         *http.ServeMux
+	token       string
+	deadLetters *spool.DeadLetters
+	hasRoute    func(string) bool
+	logger      *slog.Logger
+}
+
+// NewHandler serves the dead-letter API under /admin/. Every request must
+// carry token as a bearer token. hasRoute validates route overrides passed
+// to replay.
+func NewHandler(token string, deadLetters *spool.DeadLetters, hasRoute func(string) bool, logger *slog.Logger) *Handler {
+	h := &Handler{
+		mux:         http.NewServeMux(),
+		token:       token,
+		deadLetters: deadLetters,
+		hasRoute:    hasRoute,
+		logger:      logger,
+	}
+
+	h.mux.HandleFunc("GET /admin/deadletter", h.handleList)
+	h.mux.HandleFunc("DELETE /admin/deadletter", h.handlePurgeAll)
+	h.mux.HandleFunc("GET /admin/deadletter/{id}", h.handleShow)
+	h.mux.HandleFunc("DELETE /admin/deadletter/{id}", h.handlePurge)
+	h.mux.HandleFunc("POST /admin/deadletter/{id}/replay", h.handleReplay)
+
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		h.writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) handleList(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.deadLetters.List()
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	summaries := make([]Summary, 0, len(entries))
+	for _, e := range entries {
+		summaries = append(summaries, NewSummary(e))
+	}
+	h.writeJSON(w, http.StatusOK, summaries)
+}
+
+func (h *Handler) handleShow(w http.ResponseWriter, r *http.Request) {
+	e, err := h.deadLetters.Get(r.PathValue("id"))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.writeJSON(w, http.StatusOK, e)
+}
+
+func (h *Handler) handleReplay(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	route := r.URL.Query().Get("route")
+	if route != "" && !h.hasRoute(route) {
+		h.writeJSON(w, http.StatusBadRequest, errorResponse{Error: "unknown route " + route})
+		return
+	}
+
+	if err := h.deadLetters.Replay(id, route); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.logger.Info("replayed dead letter", "id", id, "route", route)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handlePurge(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := h.deadLetters.Purge(id); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.logger.Info("purged dead letter", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handlePurgeAll(w http.ResponseWriter, r *http.Request) {
+	n, err := h.deadLetters.PurgeAll()
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	h.logger.Info("purged dead letters", "count", n)
+	h.writeJSON(w, http.StatusOK, map[string]int{"purged": n})
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, spool.ErrNotFound) {
+		h.writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+	h.logger.Error("admin request failed", "error", err)
+	h.writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "internal error"})
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.logger.Error("failed to encode admin response", "error", err)
+	}
+}
@@ -0,0 +1,110 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alex/smtp-gotify/internal/mail"
+	"github.com/alex/smtp-gotify/internal/spool"
+)
+
+type rejectForwarder struct{}
+
+func (rejectForwarder) Forward(ctx context.Context, msg *mail.Message) error {
+	return errors.New("gotify down")
+}
+
+func newTestHandler(t *testing.T) (*Handler, string) {
+	t.Helper()
+	s, err := spool.New(spool.Config{
+		Dir:         t.TempDir(),
+		Forwarder:   rejectForwarder{},
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  time.Millisecond,
+		MaxAttempts: 1,
+		Logger:      slog.Default(),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Forward(context.Background(), &mail.Message{Subject: "Lost"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	d := s.DeadLetters()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		dead, _ := d.List()
+		if len(dead) == 1 {
+			h := NewHandler("secret", d, func(name string) bool { return name == "alerts" }, slog.Default())
+			return h, dead[0].ID
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("message did not reach the dead-letter queue")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func do(h http.Handler, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandler_Unauthorized(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	if w := do(h, http.MethodGet, "/admin/deadletter", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %d", w.Code)
+	}
+	if w := do(h, http.MethodGet, "/admin/deadletter", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 with wrong token, got %d", w.Code)
+	}
+}
+
+func TestHandler_DeadLetters(t *testing.T) {
+	h, id := newTestHandler(t)
+
+	w := do(h, http.MethodGet, "/admin/deadletter", "secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var list []Summary
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != id || list[0].Subject != "Lost" || list[0].Reason != "gotify down" {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+
+	if w := do(h, http.MethodGet, "/admin/deadletter/"+id, "secret"); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for show, got %d", w.Code)
+	}
+	if w := do(h, http.MethodGet, "/admin/deadletter/missing", "secret"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown id, got %d", w.Code)
+	}
+	if w := do(h, http.MethodPost, "/admin/deadletter/"+id+"/replay?route=nope", "secret"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown route, got %d", w.Code)
+	}
+	if w := do(h, http.MethodPost, "/admin/deadletter/"+id+"/replay?route=alerts", "secret"); w.Code != http.StatusNoContent {
+		t.Errorf("expected 204 for replay, got %d", w.Code)
+	}
+	if w := do(h, http.MethodDelete, "/admin/deadletter/"+id, "secret"); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 after replay, got %d", w.Code)
+	}
+}
@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticator verifies SMTP AUTH credentials.
+type Authenticator interface {
+	Authenticate(username, password string) error
+}
+
+// Users maps usernames to password hashes (bcrypt, argon2 or plain text
+// prefixed with "plain:").
+type Users map[string]string
+
+func (u Users) Authenticate(username, password string) error {
+	hash, ok := u[username]
+	if !ok {
+		return ErrInvalidCredentials
+	}
+	if !verifyPassword(hash, password) {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// Load builds an authenticator from an htpasswd-style file and a list of
+// static user:hash entries. Static entries take precedence over the file.
+// It returns nil if no users are configured.
+func Load(file string, entries []string) (Authenticator, error) {
+	users := Users{}
+
+	if file != "" {
+		fileUsers, err := LoadHtpasswd(file)
+		if err != nil {
+			return nil, err
+		}
+		for name, hash := range fileUsers {
+			users[name] = hash
+		}
+	}
+
+	staticUsers, err := ParseUsers(entries)
+	if err != nil {
+		return nil, err
+	}
+	for name, hash := range staticUsers {
+		users[name] = hash
+	}
+
+	if len(users) == 0 {
+		return nil, nil
+	}
+	return users, nil
+}
+
+func LoadHtpasswd(path string) (Users, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users, err := ReadHtpasswd(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return users, nil
+}
+
+// ReadHtpasswd parses user:hash lines, skipping blank lines and # comments.
+func ReadHtpasswd(r io.Reader) (Users, error) {
+	users := Users{}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, hash, err := parseEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		users[name] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func ParseUsers(entries []string) (Users, error) {
+	users := Users{}
+	for _, entry := range entries {
+		name, hash, err := parseEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		users[name] = hash
+	}
+	return users, nil
+}
+
+func parseEntry(s string) (name, hash string, err error) {
+	name, hash, ok := strings.Cut(s, ":")
+	if !ok || name == "" || hash == "" {
+		return "", "", errors.New("expected user:hash entry")
+	}
+	if err := checkHash(hash); err != nil {
+		return "", "", fmt.Errorf("user %s: %w", name, err)
+	}
+	return name, hash, nil
+}
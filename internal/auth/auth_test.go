@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUsers_Authenticate(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte("secret"), salt, 1, 64*1024, 1, 32)
+	argonHash := fmt.Sprintf("$argon2id$v=%d$m=65536,t=1,p=1$%s$%s",
+		argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+
+	users := Users{
+		"bcrypt": string(bcryptHash),
+		"argon":  argonHash,
+		"plain":  "plain:secret",
+	}
+
+	for name := range users {
+		if err := users.Authenticate(name, "secret"); err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+		if err := users.Authenticate(name, "wrong"); err != ErrInvalidCredentials {
+			t.Errorf("%s: expected ErrInvalidCredentials, got %v", name, err)
+		}
+	}
+
+	if err := users.Authenticate("unknown", "secret"); err != ErrInvalidCredentials {
+		t.Errorf("expected ErrInvalidCredentials for unknown user, got %v", err)
+	}
+}
+
+func TestUsers_AuthenticateUnsupportedHash(t *testing.T) {
+	hashes := []string{
+		"$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/",
+		"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+		"$6$salt$IxDD3jeSOb5eB1CX5LBsqZFVkJdido3OUILO5Ifz5iwMuTS4XMS130MTSuDDl3aCI6WouIL9AjRbLCelDCy.g.",
+		"secret",
+	}
+	for _, hash := range hashes {
+		users := Users{"user": hash}
+		if err := users.Authenticate("user", hash); err != ErrInvalidCredentials {
+			t.Errorf("%s: expected the hash itself to be rejected as password, got %v", hash, err)
+		}
+		if _, err := ParseUsers([]string{"user:" + hash}); err == nil {
+			t.Errorf("%s: expected unsupported hash to be rejected", hash)
+		}
+	}
+}
+
+func TestParseUsers_InvalidArgon2(t *testing.T) {
+	salt := base64.RawStdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	key := base64.RawStdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	hashes := []string{
+		"$argon2id$v=19$m=65536,t=0,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=65536,t=1,p=0$" + salt + "$" + key,
+		"$argon2id$v=19$m=0,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=65536,t=1,p=256$" + salt + "$" + key,
+		"$argon2id$v=19$m=4294967295,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=16$m=65536,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=65536,t=1,p=1$!!$" + key,
+		"$argon2id$v=19$m=65536,t=1,p=1$" + salt + "$",
+		"$argon2id$v=19$m=65536,t=1$" + salt + "$" + key,
+	}
+	for _, hash := range hashes {
+		if _, err := ParseUsers([]string{"user:" + hash}); err == nil {
+			t.Errorf("%s: expected invalid argon2 hash to be rejected", hash)
+		}
+		// Must not panic when used without validation
+		if err := (Users{"user": hash}).Authenticate("user", "secret"); err != ErrInvalidCredentials {
+			t.Errorf("%s: expected ErrInvalidCredentials, got %v", hash, err)
+		}
+	}
+}
+
+func TestReadHtpasswd(t *testing.T) {
+	input := `# devices
+printer:plain:one
+
+nas:plain:two
+`
+	users, err := ReadHtpasswd(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(users) != 2 || users["printer"] != "plain:one" || users["nas"] != "plain:two" {
+		t.Errorf("unexpected users: %v", users)
+	}
+
+	if _, err := ReadHtpasswd(strings.NewReader("missing-hash\n")); err == nil {
+		t.Error("expected error for malformed line")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(path, []byte("printer:plain:one\nnas:plain:two\n"), 0o600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	a, err := Load(path, []string{"nas:plain:override", "router:plain:three"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := a.Authenticate("nas", "override"); err != nil {
+		t.Errorf("expected static entry to override file: %v", err)
+	}
+	if err := a.Authenticate("router", "three"); err != nil {
+		t.Errorf("expected static entry to authenticate: %v", err)
+	}
+
+	a, err = Load("", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a != nil {
+		t.Error("expected nil authenticator when no users are configured")
+	}
+}
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// plainPrefix marks a password stored as plain text.
+const plainPrefix = "plain:"
+
+var errUnsupportedHash = errors.New("unsupported password hash, use bcrypt, argon2 or plain:<password>")
+
+// maxArgon2Memory bounds the memory of an argon2 hash in KiB, so a bad
+// entry cannot make each login allocate gigabytes.
+const maxArgon2Memory = 1 << 20
+
+// checkHash reports whether hash is in a format verifyPassword understands.
+// Argon2 parameters are checked in full, since argon2 panics on bad ones.
+func checkHash(hash string) error {
+	switch {
+	case isArgon2(hash):
+		_, err := parseArgon2(hash)
+		return err
+	case isBcrypt(hash), strings.HasPrefix(hash, plainPrefix):
+		return nil
+	default:
+		return errUnsupportedHash
+	}
+}
+
+// verifyPassword checks password against hash. Unknown formats, such as
+// htpasswd's $apr1$ or {SHA}, never match.
+func verifyPassword(hash, password string) bool {
+	switch {
+	case isBcrypt(hash):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case isArgon2(hash):
+		ok, err := verifyArgon2(hash, password)
+		return err == nil && ok
+	case strings.HasPrefix(hash, plainPrefix):
+		return subtle.ConstantTimeCompare([]byte(hash[len(plainPrefix):]), []byte(password)) == 1
+	default:
+		return false
+	}
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func isArgon2(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$") || strings.HasPrefix(hash, "$argon2i$")
+}
+
+// argon2Hash is a parsed PHC-formatted hash such as
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>.
+type argon2Hash struct {
+	id      bool
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2(hash string) (*argon2Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return nil, errors.New("invalid argon2 hash")
+	}
+	h := &argon2Hash{id: parts[1] == "argon2id"}
+
+	version, ok := strings.CutPrefix(parts[2], "v=")
+	if !ok || version != strconv.Itoa(argon2.Version) {
+		return nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+
+	params := strings.Split(parts[3], ",")
+	if len(params) != 3 {
+		return nil, fmt.Errorf("invalid argon2 parameters %q", parts[3])
+	}
+	for i, name := range []string{"m", "t", "p"} {
+		v, ok := strings.CutPrefix(params[i], name+"=")
+		if !ok {
+			return nil, fmt.Errorf("invalid argon2 parameters %q", parts[3])
+		}
+		bits := 32
+		if name == "p" {
+			bits = 8
+		}
+		n, err := strconv.ParseUint(v, 10, bits)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid argon2 parameter %s=%s", name, v)
+		}
+		switch name {
+		case "m":
+			h.memory = uint32(n)
+		case "t":
+			h.time = uint32(n)
+		case "p":
+			h.threads = uint8(n)
+		}
+	}
+	if h.memory > maxArgon2Memory {
+		return nil, fmt.Errorf("argon2 memory %d KiB exceeds %d KiB", h.memory, maxArgon2Memory)
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(h.salt) == 0 {
+		return nil, errors.New("invalid argon2 salt")
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+		return nil, errors.New("invalid argon2 key")
+	}
+	return h, nil
+}
+
+func verifyArgon2(hash, password string) (bool, error) {
+	h, err := parseArgon2(hash)
+	if err != nil {
+		return false, err
+	}
+
+	var derived []byte
+	if h.id {
+		derived = argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	} else {
+		derived = argon2.Key([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	}
+
+	return subtle.ConstantTimeCompare(derived, h.key) == 1, nil
+}
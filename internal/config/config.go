@@ -4,13 +4,23 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/alex/smtp-gotify/internal/ratelimit"
 )
 
 type Config struct {
 	Gotify GotifyConfig
+	// Webhooks are destinations that receive messages as JSON requests.
+	Webhooks []WebhookConfig
+	// Ntfy are destinations publishing to ntfy topics.
+	Ntfy   []NtfyConfig
 	SMTP   SMTPConfig
+	Spool  SpoolConfig
 	Health HealthConfig
 	Log    LogConfig
 }
@@ -22,17 +32,211 @@ type GotifyConfig struct {
 	Markdown        bool
 	TitleTemplate   string
 	MessageTemplate string
+	Users           []RouteConfig
+	Recipients      []RouteConfig
+	Retries         int
+	RetryMin        time.Duration
+	RetryMax        time.Duration
+	Timeout         time.Duration
+	Concurrency     int
+	// Destinations are additional named Gotify servers. GOTIFY_URL, when
+	// set, is the destination named "default".
+	Destinations []DestinationConfig
+	// DefaultDestinations receive messages that no route sends elsewhere.
+	DefaultDestinations []string
+	HTTP                HTTPConfig
+	// BreakerThreshold consecutive failures open a destination's circuit,
+	// 0 disables the breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// ClientToken is a Gotify client token, needed to delete notifications
+	// and create applications.
+	ClientToken string
+	Correlation CorrelationConfig
+	Provision   ProvisionConfig
+	// MaxMessageSize bounds notification bodies in bytes, 0 means no
+	// limit. Longer bodies are handled according to OversizeStrategy.
+	MaxMessageSize   int
+	OversizeStrategy string
+	MaxParts         int
+}
+
+// CorrelationConfig links firing and resolved alert mails when Key is set.
+type CorrelationConfig struct {
+	// Key is a template extracting the alert a mail is about.
+	Key string
+	// ResolvedPattern is a regular expression matching resolved subjects.
+	ResolvedPattern  string
+	ResolvedNote     bool
+	ResolvedPriority int
+	// Supersede deletes the earlier notification of a repeated alert.
+	Supersede bool
+	TTL       time.Duration
+}
+
+// ProvisionConfig creates a Gotify application per sending system when By
+// is set.
+type ProvisionConfig struct {
+	// By selects what an application is created for: sender-domain, user
+	// or recipient.
+	By string
+	// Allow lists the keys applications are created for, all if empty.
+	Allow []string
+	// MaxApps bounds the number of provisioned applications, 0 means no
+	// limit.
+	MaxApps     int
+	NamePrefix  string
+	Description string
+	// Icon is an image file uploaded for each new application.
+	Icon string
+}
+
+// HTTPConfig holds TLS, proxy and connection settings for reaching Gotify.
+type HTTPConfig struct {
+	CAFile              string
+	ClientCert          string
+	ClientKey           string
+	InsecureSkipVerify  bool
+	Proxy               string
+	MaxIdleConns        int
+	IdleConnTimeout     time.Duration
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+}
+
+// DestinationConfig describes one Gotify server. Unset fields other than the
+// URL and tokens inherit the global Gotify settings.
+type DestinationConfig struct {
+	Name             string
+	URL              string
+	Tokens           []string
+	Priority         int
+	Markdown         bool
+	TitleTemplate    string
+	MessageTemplate  string
+	Retries          int
+	RetryMin         time.Duration
+	RetryMax         time.Duration
+	Timeout          time.Duration
+	Concurrency      int
+	HTTP             HTTPConfig
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	ClientToken      string
+	Correlation      CorrelationConfig
+	Provision        ProvisionConfig
+	MaxMessageSize   int
+	OversizeStrategy string
+	MaxParts         int
+}
+
+// WebhookConfig describes an HTTP endpoint messages are sent to.
+type WebhookConfig struct {
+	Name   string
+	URL    string
+	Method string
+	// Headers are "Name: Value" lines added to each request.
+	Headers      []string
+	BearerToken  string
+	Username     string
+	Password     string
+	BodyTemplate string
+	Timeout      time.Duration
+}
+
+// NtfyConfig describes an ntfy server and the topics messages are published
+// to. Unset templates, priority and markdown inherit the global Gotify
+// settings.
+type NtfyConfig struct {
+	Name            string
+	URL             string
+	Topics          []string
+	Token           string
+	Username        string
+	Password        string
+	Priority        int
+	Tags            []string
+	ClickTemplate   string
+	AttachTemplate  string
+	Markdown        bool
+	TitleTemplate   string
+	MessageTemplate string
+	Timeout         time.Duration
+}
+
+// RouteConfig overrides Gotify delivery for an authenticated SMTP user or an
+// envelope recipient. Unset fields inherit the global Gotify settings.
+type RouteConfig struct {
+	Name            string
+	Tokens          []string
+	Priority        int
+	TitleTemplate   string
+	MessageTemplate string
+	// Destinations, when set, replaces the default destinations for
+	// messages matching the route.
+	Destinations []string
 }
 
 type SMTPConfig struct {
-	Listen  string
-	Domain  string
-	MaxSize int
+	Listen          string
+	Domain          string
+	MaxSize         int
+	AuthFile        string
+	AuthUsers       []string
+	AuthRequired    bool
+	TLSCert         string
+	TLSKey          string
+	TLSClientCA     string
+	TLSRequired     bool
+	Listeners       []ListenerConfig
+	AcceptDomains   []string
+	AcceptAddresses []string
+	MaxRecipients   int
+	AllowNetworks   []string
+	DenyNetworks    []string
+	TrustedNetworks []string
+	RateLimitIP     string
+	RateLimitUser   string
+	RateLimitSender string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	DataTimeout     time.Duration
+	MaxConnections  int
+	MaxConnsPerIP   int
+	MaxLineLength   int
+	Banner          string
+	ProxyTrusted    []string
+	DeliveryTimeout time.Duration
+	ShutdownGrace   time.Duration
+}
+
+// ListenerConfig describes one SMTP listener. All listeners share the same
+// backend but can enforce their own TLS and authentication requirements.
+type ListenerConfig struct {
+	Name          string
+	Network       string // tcp or unix
+	Addr          string
+	Protocol      string // smtp or lmtp
+	TLS           string // none, starttls or implicit
+	AuthRequired  bool
+	TLSRequired   bool
+	ProxyProtocol bool
+}
+
+// SpoolConfig enables queued delivery when Dir is set.
+type SpoolConfig struct {
+	Dir         string
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	MaxAttempts int
+	Workers     int
 }
 
 type HealthConfig struct {
 	Enabled bool
 	Listen  string
+	// AdminToken enables the admin API on the health server when set.
+	AdminToken string
 }
 
 type LogConfig struct {
@@ -43,21 +247,66 @@ type LogConfig struct {
 func Load() (*Config, error) {
 	cfg := &Config{
 		Gotify: GotifyConfig{
-			URL:             getEnv("GOTIFY_URL", ""),
-			Tokens:          parseTokens(getEnv("GOTIFY_TOKEN", "")),
-			Priority:        getEnvInt("GOTIFY_PRIORITY", 5),
-			Markdown:        getEnvBool("GOTIFY_MARKDOWN", false),
-			TitleTemplate:   getEnv("GOTIFY_TITLE_TEMPLATE", "{{.Subject}}"),
-			MessageTemplate: getEnv("GOTIFY_MESSAGE_TEMPLATE", "From: {{.From}}\nTo: {{.To}}\n---\n{{.Body}}"),
+			URL:              getEnv("GOTIFY_URL", ""),
+			Tokens:           parseTokens(getEnv("GOTIFY_TOKEN", "")),
+			Priority:         getEnvInt("GOTIFY_PRIORITY", 5),
+			Markdown:         getEnvBool("GOTIFY_MARKDOWN", false),
+			TitleTemplate:    getEnv("GOTIFY_TITLE_TEMPLATE", "{{.Subject}}"),
+			MessageTemplate:  getEnv("GOTIFY_MESSAGE_TEMPLATE", "From: {{.From}}\nTo: {{.To}}\n---\n{{.Body}}"),
+			Retries:          getEnvInt("GOTIFY_RETRIES", 2),
+			RetryMin:         getEnvDuration("GOTIFY_RETRY_MIN", 500*time.Millisecond),
+			RetryMax:         getEnvDuration("GOTIFY_RETRY_MAX", 10*time.Second),
+			Timeout:          getEnvDuration("GOTIFY_TIMEOUT", 30*time.Second),
+			Concurrency:      getEnvInt("GOTIFY_CONCURRENCY", 4),
+			BreakerThreshold: getEnvInt("GOTIFY_BREAKER_THRESHOLD", 5),
+			BreakerCooldown:  getEnvDuration("GOTIFY_BREAKER_COOLDOWN", 30*time.Second),
+			ClientToken:      getEnv("GOTIFY_CLIENT_TOKEN", ""),
+			MaxMessageSize:   getEnvInt("GOTIFY_MAX_MESSAGE_SIZE", 0),
+			OversizeStrategy: getEnv("GOTIFY_OVERSIZE_STRATEGY", "truncate"),
+			MaxParts:         getEnvInt("GOTIFY_MAX_PARTS", 10),
 		},
 		SMTP: SMTPConfig{
-			Listen:  getEnv("SMTP_LISTEN", ":2525"),
-			Domain:  getEnv("SMTP_DOMAIN", "localhost"),
-			MaxSize: getEnvInt("SMTP_MAX_SIZE", 10485760),
+			Listen:          getEnv("SMTP_LISTEN", ":2525"),
+			Domain:          getEnv("SMTP_DOMAIN", "localhost"),
+			MaxSize:         getEnvInt("SMTP_MAX_SIZE", 10485760),
+			AuthFile:        getEnv("SMTP_AUTH_FILE", ""),
+			AuthUsers:       strings.Fields(getEnv("SMTP_AUTH_USERS", "")),
+			AuthRequired:    getEnvBool("SMTP_AUTH_REQUIRED", false),
+			TLSCert:         getEnv("SMTP_TLS_CERT", ""),
+			TLSKey:          getEnv("SMTP_TLS_KEY", ""),
+			TLSClientCA:     getEnv("SMTP_TLS_CLIENT_CA", ""),
+			TLSRequired:     getEnvBool("SMTP_TLS_REQUIRED", false),
+			AcceptDomains:   parseTokens(getEnv("SMTP_ACCEPT_DOMAINS", "")),
+			AcceptAddresses: parseTokens(getEnv("SMTP_ACCEPT_ADDRESSES", "")),
+			MaxRecipients:   getEnvInt("SMTP_MAX_RECIPIENTS", 0),
+			AllowNetworks:   parseTokens(getEnv("SMTP_ALLOW_NETWORKS", "")),
+			DenyNetworks:    parseTokens(getEnv("SMTP_DENY_NETWORKS", "")),
+			TrustedNetworks: parseTokens(getEnv("SMTP_TRUSTED_NETWORKS", "")),
+			RateLimitIP:     getEnv("SMTP_RATE_LIMIT_IP", ""),
+			RateLimitUser:   getEnv("SMTP_RATE_LIMIT_USER", ""),
+			RateLimitSender: getEnv("SMTP_RATE_LIMIT_SENDER", ""),
+			ReadTimeout:     getEnvDuration("SMTP_READ_TIMEOUT", 60*time.Second),
+			WriteTimeout:    getEnvDuration("SMTP_WRITE_TIMEOUT", 60*time.Second),
+			DataTimeout:     getEnvDuration("SMTP_DATA_TIMEOUT", 5*time.Minute),
+			MaxConnections:  getEnvInt("SMTP_MAX_CONNECTIONS", 0),
+			MaxConnsPerIP:   getEnvInt("SMTP_MAX_CONNECTIONS_PER_IP", 0),
+			MaxLineLength:   getEnvInt("SMTP_MAX_LINE_LENGTH", 2000),
+			Banner:          getEnv("SMTP_BANNER", ""),
+			ProxyTrusted:    parseTokens(getEnv("SMTP_PROXY_TRUSTED", "")),
+			DeliveryTimeout: getEnvDuration("SMTP_DELIVERY_TIMEOUT", 60*time.Second),
+			ShutdownGrace:   getEnvDuration("SMTP_SHUTDOWN_GRACE_PERIOD", 30*time.Second),
+		},
+		Spool: SpoolConfig{
+			Dir:         getEnv("SPOOL_DIR", ""),
+			MinBackoff:  getEnvDuration("SPOOL_RETRY_MIN", 30*time.Second),
+			MaxBackoff:  getEnvDuration("SPOOL_RETRY_MAX", time.Hour),
+			MaxAttempts: getEnvInt("SPOOL_MAX_ATTEMPTS", 0),
+			Workers:     getEnvInt("SPOOL_WORKERS", 4),
 		},
 		Health: HealthConfig{
-			Enabled: getEnvBool("HEALTH_ENABLED", true),
-			Listen:  getEnv("HEALTH_LISTEN", ":8080"),
+			Enabled:    getEnvBool("HEALTH_ENABLED", true),
+			Listen:     getEnv("HEALTH_LISTEN", ":8080"),
+			AdminToken: getEnv("ADMIN_TOKEN", ""),
 		},
 		Log: LogConfig{
 			Level:  getEnv("LOG_LEVEL", "info"),
@@ -65,6 +314,30 @@ func Load() (*Config, error) {
 		},
 	}
 
+	cfg.Gotify.HTTP = loadHTTP("GOTIFY_", HTTPConfig{
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+		DialTimeout:         10 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	})
+	cfg.Gotify.Correlation = loadCorrelation("GOTIFY_", CorrelationConfig{
+		ResolvedPattern:  `(?i)\bresolved\b`,
+		ResolvedNote:     true,
+		ResolvedPriority: 1,
+		TTL:              7 * 24 * time.Hour,
+	})
+	cfg.Gotify.Provision = loadProvision("GOTIFY_", ProvisionConfig{
+		MaxApps:     50,
+		Description: "Created by smtp-gotify",
+	})
+	cfg.Gotify.Destinations = loadDestinations(cfg.Gotify)
+	cfg.Webhooks = loadWebhooks()
+	cfg.Ntfy = loadNtfy(cfg.Gotify)
+	cfg.Gotify.DefaultDestinations = defaultDestinations(cfg)
+	cfg.Gotify.Users = loadRoutes("GOTIFY_USERS", "GOTIFY_USER_", cfg.Gotify)
+	cfg.Gotify.Recipients = loadRoutes("GOTIFY_RECIPIENTS", "GOTIFY_RECIPIENT_", cfg.Gotify)
+	cfg.SMTP.Listeners = loadListeners(cfg.SMTP)
+
 	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
@@ -75,18 +348,68 @@ func Load() (*Config, error) {
 func (c *Config) Validate() error {
 	var errs []error
 
-	if c.Gotify.URL == "" {
-		errs = append(errs, errors.New("GOTIFY_URL is required"))
+	if c.Gotify.URL == "" && len(c.Gotify.Destinations) == 0 && len(c.Webhooks) == 0 && len(c.Ntfy) == 0 {
+		errs = append(errs, errors.New("GOTIFY_URL, GOTIFY_DESTINATIONS, WEBHOOK_DESTINATIONS or NTFY_DESTINATIONS is required"))
 	}
 
-	if len(c.Gotify.Tokens) == 0 {
+	if c.Gotify.URL != "" && len(c.Gotify.Tokens) == 0 {
 		errs = append(errs, errors.New("GOTIFY_TOKEN is required"))
 	}
 
+	errs = append(errs, c.Gotify.HTTP.validate("GOTIFY")...)
+	errs = append(errs, c.Gotify.Correlation.validate("GOTIFY", c.Gotify.ClientToken)...)
+	errs = append(errs, c.Gotify.Provision.validate("GOTIFY", c.Gotify.ClientToken)...)
+	errs = append(errs, c.Gotify.validateDestinations(c.Webhooks, c.Ntfy)...)
+
 	if c.Gotify.Priority < 0 || c.Gotify.Priority > 10 {
 		errs = append(errs, fmt.Errorf("GOTIFY_PRIORITY must be between 0 and 10, got %d", c.Gotify.Priority))
 	}
 
+	if c.Gotify.Retries < 0 {
+		errs = append(errs, fmt.Errorf("GOTIFY_RETRIES must not be negative, got %d", c.Gotify.Retries))
+	}
+
+	if c.Gotify.RetryMin < 0 || c.Gotify.RetryMax < c.Gotify.RetryMin {
+		errs = append(errs, fmt.Errorf("GOTIFY_RETRY_MIN must not be negative or above GOTIFY_RETRY_MAX, got %s and %s", c.Gotify.RetryMin, c.Gotify.RetryMax))
+	}
+
+	if c.Gotify.Concurrency < 0 {
+		errs = append(errs, fmt.Errorf("GOTIFY_CONCURRENCY must not be negative, got %d", c.Gotify.Concurrency))
+	}
+
+	if c.Gotify.BreakerThreshold < 0 || c.Gotify.BreakerCooldown < 0 {
+		errs = append(errs, errors.New("GOTIFY_BREAKER_THRESHOLD and GOTIFY_BREAKER_COOLDOWN must not be negative"))
+	}
+
+	if c.Gotify.BreakerThreshold > 0 && c.Gotify.BreakerCooldown == 0 {
+		errs = append(errs, errors.New("GOTIFY_BREAKER_COOLDOWN must be positive when the breaker is enabled"))
+	}
+
+	if err := validateLimit(c.Gotify.MaxMessageSize, c.Gotify.OversizeStrategy, c.Gotify.MaxParts); err != nil {
+		errs = append(errs, fmt.Errorf("GOTIFY: %w", err))
+	}
+
+	if c.Gotify.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("GOTIFY_TIMEOUT must not be negative, got %s", c.Gotify.Timeout))
+	}
+
+	for _, r := range slices.Concat(c.Gotify.Users, c.Gotify.Recipients) {
+		if r.Priority < 0 || r.Priority > 10 {
+			errs = append(errs, fmt.Errorf("priority for route %s must be between 0 and 10, got %d", r.Name, r.Priority))
+		}
+	}
+
+	haveUsers := c.SMTP.AuthFile != "" || len(c.SMTP.AuthUsers) > 0
+	if len(c.SMTP.TrustedNetworks) > 0 && !haveUsers {
+		errs = append(errs, errors.New("SMTP_TRUSTED_NETWORKS needs SMTP_AUTH_FILE or SMTP_AUTH_USERS for untrusted clients"))
+	}
+	for _, l := range c.SMTP.Listeners {
+		errs = append(errs, l.validate(c.SMTP.TLSCert != "", haveUsers)...)
+		if l.ProxyProtocol && len(c.SMTP.ProxyTrusted) == 0 {
+			errs = append(errs, fmt.Errorf("listener %s: PROXY protocol needs SMTP_PROXY_TRUSTED", l.Name))
+		}
+	}
+
 	validLevels := map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
 	if !validLevels[c.Log.Level] {
 		errs = append(errs, fmt.Errorf("LOG_LEVEL must be one of debug/info/warn/error, got %s", c.Log.Level))
@@ -101,6 +424,62 @@ func (c *Config) Validate() error {
 		errs = append(errs, fmt.Errorf("SMTP_MAX_SIZE must be positive, got %d", c.SMTP.MaxSize))
 	}
 
+	for key, spec := range map[string]string{
+		"SMTP_RATE_LIMIT_IP":     c.SMTP.RateLimitIP,
+		"SMTP_RATE_LIMIT_USER":   c.SMTP.RateLimitUser,
+		"SMTP_RATE_LIMIT_SENDER": c.SMTP.RateLimitSender,
+	} {
+		if _, err := ratelimit.Parse(spec); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+
+	if c.SMTP.MaxRecipients < 0 {
+		errs = append(errs, fmt.Errorf("SMTP_MAX_RECIPIENTS must not be negative, got %d", c.SMTP.MaxRecipients))
+	}
+
+	if c.SMTP.MaxConnections < 0 {
+		errs = append(errs, fmt.Errorf("SMTP_MAX_CONNECTIONS must not be negative, got %d", c.SMTP.MaxConnections))
+	}
+
+	if c.SMTP.MaxConnsPerIP < 0 {
+		errs = append(errs, fmt.Errorf("SMTP_MAX_CONNECTIONS_PER_IP must not be negative, got %d", c.SMTP.MaxConnsPerIP))
+	}
+
+	if c.SMTP.MaxLineLength < 0 {
+		errs = append(errs, fmt.Errorf("SMTP_MAX_LINE_LENGTH must not be negative, got %d", c.SMTP.MaxLineLength))
+	}
+
+	if c.SMTP.ReadTimeout < 0 || c.SMTP.WriteTimeout < 0 || c.SMTP.DataTimeout < 0 || c.SMTP.DeliveryTimeout < 0 {
+		errs = append(errs, errors.New("SMTP_READ_TIMEOUT, SMTP_WRITE_TIMEOUT, SMTP_DATA_TIMEOUT and SMTP_DELIVERY_TIMEOUT must not be negative"))
+	}
+
+	if c.SMTP.ShutdownGrace < 0 {
+		errs = append(errs, fmt.Errorf("SMTP_SHUTDOWN_GRACE_PERIOD must not be negative, got %s", c.SMTP.ShutdownGrace))
+	}
+
+	if c.Spool.Dir != "" {
+		if c.Spool.MinBackoff <= 0 || c.Spool.MaxBackoff < c.Spool.MinBackoff {
+			errs = append(errs, fmt.Errorf("SPOOL_RETRY_MIN must be positive and not above SPOOL_RETRY_MAX, got %s and %s", c.Spool.MinBackoff, c.Spool.MaxBackoff))
+		}
+
+		if c.Spool.MaxAttempts < 0 {
+			errs = append(errs, fmt.Errorf("SPOOL_MAX_ATTEMPTS must not be negative, got %d", c.Spool.MaxAttempts))
+		}
+
+		if c.Spool.Workers < 0 {
+			errs = append(errs, fmt.Errorf("SPOOL_WORKERS must not be negative, got %d", c.Spool.Workers))
+		}
+	}
+
+	if (c.SMTP.TLSCert == "") != (c.SMTP.TLSKey == "") {
+		errs = append(errs, errors.New("SMTP_TLS_CERT and SMTP_TLS_KEY must be set together"))
+	}
+
+	if c.SMTP.TLSCert == "" && c.SMTP.TLSClientCA != "" {
+		errs = append(errs, errors.New("SMTP_TLS_CLIENT_CA needs SMTP_TLS_CERT"))
+	}
+
 	if len(errs) > 0 {
 		return errors.Join(errs...)
 	}
@@ -108,6 +487,447 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+func (g GotifyConfig) validateDestinations(webhooks []WebhookConfig, ntfy []NtfyConfig) []error {
+	var errs []error
+
+	known := map[string]bool{}
+	if g.URL != "" {
+		known["default"] = true
+	}
+	for _, d := range g.Destinations {
+		if known[d.Name] {
+			errs = append(errs, fmt.Errorf("destination %s is defined twice", d.Name))
+		}
+		known[d.Name] = true
+
+		if d.URL == "" {
+			errs = append(errs, fmt.Errorf("destination %s: URL is required", d.Name))
+		}
+		if len(d.Tokens) == 0 {
+			errs = append(errs, fmt.Errorf("destination %s: token is required", d.Name))
+		}
+		if d.Priority < 0 || d.Priority > 10 {
+			errs = append(errs, fmt.Errorf("destination %s: priority must be between 0 and 10, got %d", d.Name, d.Priority))
+		}
+		errs = append(errs, d.HTTP.validate("destination "+d.Name)...)
+		errs = append(errs, d.Correlation.validate("destination "+d.Name, d.ClientToken)...)
+		errs = append(errs, d.Provision.validate("destination "+d.Name, d.ClientToken)...)
+		if err := validateLimit(d.MaxMessageSize, d.OversizeStrategy, d.MaxParts); err != nil {
+			errs = append(errs, fmt.Errorf("destination %s: %w", d.Name, err))
+		}
+		if d.Retries < 0 || d.RetryMin < 0 || d.RetryMax < d.RetryMin || d.Timeout < 0 || d.Concurrency < 0 {
+			errs = append(errs, fmt.Errorf("destination %s: retry, timeout and concurrency settings must not be negative", d.Name))
+		}
+		if d.BreakerThreshold < 0 || d.BreakerCooldown < 0 || (d.BreakerThreshold > 0 && d.BreakerCooldown == 0) {
+			errs = append(errs, fmt.Errorf("destination %s: breaker threshold must not be negative and needs a positive cooldown", d.Name))
+		}
+	}
+
+	for _, w := range webhooks {
+		if known[w.Name] {
+			errs = append(errs, fmt.Errorf("destination %s is defined twice", w.Name))
+		}
+		known[w.Name] = true
+		errs = append(errs, w.validate()...)
+	}
+	for _, n := range ntfy {
+		if known[n.Name] {
+			errs = append(errs, fmt.Errorf("destination %s is defined twice", n.Name))
+		}
+		known[n.Name] = true
+		errs = append(errs, n.validate()...)
+	}
+
+	for _, name := range g.DefaultDestinations {
+		if !known[name] {
+			errs = append(errs, fmt.Errorf("GOTIFY_DEFAULT_DESTINATIONS: unknown destination %s", name))
+		}
+	}
+	for _, r := range slices.Concat(g.Users, g.Recipients) {
+		for _, name := range r.Destinations {
+			if !known[name] {
+				errs = append(errs, fmt.Errorf("route %s: unknown destination %s", r.Name, name))
+			}
+		}
+	}
+
+	return errs
+}
+
+func (w WebhookConfig) validate() []error {
+	var errs []error
+	if w.URL == "" {
+		errs = append(errs, fmt.Errorf("webhook %s: URL is required", w.Name))
+	}
+	switch strings.ToUpper(w.Method) {
+	case "POST", "PUT", "PATCH":
+	default:
+		errs = append(errs, fmt.Errorf("webhook %s: method must be one of POST/PUT/PATCH, got %s", w.Name, w.Method))
+	}
+	for _, h := range w.Headers {
+		if name, _, ok := strings.Cut(h, ":"); !ok || strings.TrimSpace(name) == "" {
+			errs = append(errs, fmt.Errorf("webhook %s: header %q must be in the form Name: Value", w.Name, h))
+		}
+	}
+	if w.BearerToken != "" && w.Username != "" {
+		errs = append(errs, fmt.Errorf("webhook %s: bearer token and username are mutually exclusive", w.Name))
+	}
+	if w.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("webhook %s: timeout must not be negative, got %s", w.Name, w.Timeout))
+	}
+	return errs
+}
+
+func (n NtfyConfig) validate() []error {
+	var errs []error
+	if n.URL == "" {
+		errs = append(errs, fmt.Errorf("ntfy %s: URL is required", n.Name))
+	}
+	if len(n.Topics) == 0 {
+		errs = append(errs, fmt.Errorf("ntfy %s: topic is required", n.Name))
+	}
+	if n.Priority < 0 || n.Priority > 10 {
+		errs = append(errs, fmt.Errorf("ntfy %s: priority must be between 0 and 10, got %d", n.Name, n.Priority))
+	}
+	if n.Token != "" && n.Username != "" {
+		errs = append(errs, fmt.Errorf("ntfy %s: token and username are mutually exclusive", n.Name))
+	}
+	if n.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("ntfy %s: timeout must not be negative, got %s", n.Name, n.Timeout))
+	}
+	return errs
+}
+
+// minMessageSize leaves room for the omitted bytes marker.
+const minMessageSize = 100
+
+func validateLimit(maxSize int, strategy string, maxParts int) error {
+	if maxSize != 0 && maxSize < minMessageSize {
+		return fmt.Errorf("max message size must be 0 or at least %d, got %d", minMessageSize, maxSize)
+	}
+	switch strategy {
+	case "", "truncate", "headtail", "split":
+	default:
+		return fmt.Errorf("oversize strategy must be one of truncate/headtail/split, got %s", strategy)
+	}
+	if maxParts < 0 {
+		return fmt.Errorf("max parts must not be negative, got %d", maxParts)
+	}
+	return nil
+}
+
+func (l ListenerConfig) validate(haveCert, haveUsers bool) []error {
+	var errs []error
+
+	if l.AuthRequired && !haveUsers {
+		errs = append(errs, fmt.Errorf("listener %s: AUTH required but SMTP_AUTH_FILE and SMTP_AUTH_USERS are not set", l.Name))
+	}
+
+	if l.Addr == "" {
+		errs = append(errs, fmt.Errorf("listener %s: address is required", l.Name))
+	}
+
+	if l.Network != "tcp" && l.Network != "unix" {
+		errs = append(errs, fmt.Errorf("listener %s: network must be tcp or unix, got %s", l.Name, l.Network))
+	}
+
+	if l.ProxyProtocol && l.Network != "tcp" {
+		errs = append(errs, fmt.Errorf("listener %s: PROXY protocol is only supported on tcp listeners", l.Name))
+	}
+
+	if l.Protocol != "smtp" && l.Protocol != "lmtp" {
+		errs = append(errs, fmt.Errorf("listener %s: protocol must be smtp or lmtp, got %s", l.Name, l.Protocol))
+	}
+
+	switch l.TLS {
+	case "none":
+		if l.TLSRequired {
+			errs = append(errs, fmt.Errorf("listener %s: TLS required but TLS mode is none", l.Name))
+		}
+	case "starttls":
+		if l.TLSRequired && !haveCert {
+			errs = append(errs, fmt.Errorf("listener %s: TLS required but SMTP_TLS_CERT is not set", l.Name))
+		}
+	case "implicit":
+		if !haveCert {
+			errs = append(errs, fmt.Errorf("listener %s: implicit TLS needs SMTP_TLS_CERT", l.Name))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("listener %s: TLS mode must be one of none/starttls/implicit, got %s", l.Name, l.TLS))
+	}
+
+	return errs
+}
+
+// loadListeners reads SMTP_LISTENERS. Without it, a single listener is built
+// from SMTP_LISTEN and the global TLS and auth settings.
+func loadListeners(c SMTPConfig) []ListenerConfig {
+	names := parseTokens(getEnv("SMTP_LISTENERS", ""))
+	if len(names) == 0 {
+		return []ListenerConfig{{
+			Name:          "default",
+			Network:       "tcp",
+			Addr:          c.Listen,
+			Protocol:      "smtp",
+			TLS:           "starttls",
+			AuthRequired:  c.AuthRequired,
+			TLSRequired:   c.TLSRequired,
+			ProxyProtocol: getEnvBool("SMTP_PROXY_PROTOCOL", false),
+		}}
+	}
+
+	var listeners []ListenerConfig
+	for _, name := range names {
+		prefix := "SMTP_LISTENER_" + envName(name) + "_"
+		listeners = append(listeners, ListenerConfig{
+			Name:          name,
+			Network:       getEnv(prefix+"NETWORK", "tcp"),
+			Addr:          getEnv(prefix+"ADDR", ""),
+			Protocol:      getEnv(prefix+"PROTOCOL", "smtp"),
+			TLS:           getEnv(prefix+"TLS", "starttls"),
+			AuthRequired:  getEnvBool(prefix+"AUTH_REQUIRED", c.AuthRequired),
+			TLSRequired:   getEnvBool(prefix+"TLS_REQUIRED", c.TLSRequired),
+			ProxyProtocol: getEnvBool(prefix+"PROXY_PROTOCOL", getEnvBool("SMTP_PROXY_PROTOCOL", false)),
+		})
+	}
+	return listeners
+}
+
+// loadRoutes reads the route names listed in listKey and their overrides
+// from variables starting with prefix.
+func loadRoutes(listKey, prefix string, g GotifyConfig) []RouteConfig {
+	var routes []RouteConfig
+	for _, name := range parseTokens(getEnv(listKey, "")) {
+		prefix := prefix + envName(name) + "_"
+		tokens := parseTokens(getEnv(prefix+"TOKEN", ""))
+		if len(tokens) == 0 {
+			tokens = g.Tokens
+		}
+		routes = append(routes, RouteConfig{
+			Name:            name,
+			Tokens:          tokens,
+			Priority:        getEnvInt(prefix+"PRIORITY", g.Priority),
+			TitleTemplate:   getEnv(prefix+"TITLE_TEMPLATE", g.TitleTemplate),
+			MessageTemplate: getEnv(prefix+"MESSAGE_TEMPLATE", g.MessageTemplate),
+			Destinations:    parseTokens(getEnv(prefix+"DESTINATIONS", "")),
+		})
+	}
+	return routes
+}
+
+// loadDestinations reads GOTIFY_DESTINATIONS and the settings of each named
+// Gotify server.
+func loadDestinations(g GotifyConfig) []DestinationConfig {
+	var dests []DestinationConfig
+	for _, name := range parseTokens(getEnv("GOTIFY_DESTINATIONS", "")) {
+		prefix := "GOTIFY_DESTINATION_" + envName(name) + "_"
+		dests = append(dests, DestinationConfig{
+			Name:             name,
+			URL:              getEnv(prefix+"URL", ""),
+			Tokens:           parseTokens(getEnv(prefix+"TOKEN", "")),
+			Priority:         getEnvInt(prefix+"PRIORITY", g.Priority),
+			Markdown:         getEnvBool(prefix+"MARKDOWN", g.Markdown),
+			TitleTemplate:    getEnv(prefix+"TITLE_TEMPLATE", g.TitleTemplate),
+			MessageTemplate:  getEnv(prefix+"MESSAGE_TEMPLATE", g.MessageTemplate),
+			Retries:          getEnvInt(prefix+"RETRIES", g.Retries),
+			RetryMin:         getEnvDuration(prefix+"RETRY_MIN", g.RetryMin),
+			RetryMax:         getEnvDuration(prefix+"RETRY_MAX", g.RetryMax),
+			Timeout:          getEnvDuration(prefix+"TIMEOUT", g.Timeout),
+			Concurrency:      getEnvInt(prefix+"CONCURRENCY", g.Concurrency),
+			HTTP:             loadHTTP(prefix, g.HTTP),
+			BreakerThreshold: getEnvInt(prefix+"BREAKER_THRESHOLD", g.BreakerThreshold),
+			BreakerCooldown:  getEnvDuration(prefix+"BREAKER_COOLDOWN", g.BreakerCooldown),
+			ClientToken:      getEnv(prefix+"CLIENT_TOKEN", g.ClientToken),
+			Correlation:      loadCorrelation(prefix, g.Correlation),
+			Provision:        loadProvision(prefix, g.Provision),
+			MaxMessageSize:   getEnvInt(prefix+"MAX_MESSAGE_SIZE", g.MaxMessageSize),
+			OversizeStrategy: getEnv(prefix+"OVERSIZE_STRATEGY", g.OversizeStrategy),
+			MaxParts:         getEnvInt(prefix+"MAX_PARTS", g.MaxParts),
+		})
+	}
+	return dests
+}
+
+// loadWebhooks reads WEBHOOK_DESTINATIONS and the settings of each named
+// webhook.
+func loadWebhooks() []WebhookConfig {
+	var hooks []WebhookConfig
+	for _, name := range parseTokens(getEnv("WEBHOOK_DESTINATIONS", "")) {
+		prefix := "WEBHOOK_DESTINATION_" + envName(name) + "_"
+		hooks = append(hooks, WebhookConfig{
+			Name:         name,
+			URL:          getEnv(prefix+"URL", ""),
+			Method:       getEnv(prefix+"METHOD", "POST"),
+			Headers:      parseLines(getEnv(prefix+"HEADERS", "")),
+			BearerToken:  getEnv(prefix+"BEARER_TOKEN", ""),
+			Username:     getEnv(prefix+"USERNAME", ""),
+			Password:     getEnv(prefix+"PASSWORD", ""),
+			BodyTemplate: getEnv(prefix+"BODY_TEMPLATE", ""),
+			Timeout:      getEnvDuration(prefix+"TIMEOUT", 30*time.Second),
+		})
+	}
+	return hooks
+}
+
+// loadNtfy reads NTFY_DESTINATIONS and the settings of each named ntfy
+// server.
+func loadNtfy(g GotifyConfig) []NtfyConfig {
+	var servers []NtfyConfig
+	for _, name := range parseTokens(getEnv("NTFY_DESTINATIONS", "")) {
+		prefix := "NTFY_DESTINATION_" + envName(name) + "_"
+		servers = append(servers, NtfyConfig{
+			Name:            name,
+			URL:             getEnv(prefix+"URL", "https://ntfy.sh"),
+			Topics:          parseTokens(getEnv(prefix+"TOPIC", "")),
+			Token:           getEnv(prefix+"TOKEN", ""),
+			Username:        getEnv(prefix+"USERNAME", ""),
+			Password:        getEnv(prefix+"PASSWORD", ""),
+			Priority:        getEnvInt(prefix+"PRIORITY", g.Priority),
+			Tags:            parseTokens(getEnv(prefix+"TAGS", "")),
+			ClickTemplate:   getEnv(prefix+"CLICK", ""),
+			AttachTemplate:  getEnv(prefix+"ATTACH", ""),
+			Markdown:        getEnvBool(prefix+"MARKDOWN", g.Markdown),
+			TitleTemplate:   getEnv(prefix+"TITLE_TEMPLATE", g.TitleTemplate),
+			MessageTemplate: getEnv(prefix+"MESSAGE_TEMPLATE", g.MessageTemplate),
+			Timeout:         getEnvDuration(prefix+"TIMEOUT", g.Timeout),
+		})
+	}
+	return servers
+}
+
+// loadHTTP reads the HTTP client settings from variables starting with
+// prefix, falling back to def.
+func loadHTTP(prefix string, def HTTPConfig) HTTPConfig {
+	return HTTPConfig{
+		CAFile:              getEnv(prefix+"CA_FILE", def.CAFile),
+		ClientCert:          getEnv(prefix+"CLIENT_CERT", def.ClientCert),
+		ClientKey:           getEnv(prefix+"CLIENT_KEY", def.ClientKey),
+		InsecureSkipVerify:  getEnvBool(prefix+"INSECURE_SKIP_VERIFY", def.InsecureSkipVerify),
+		Proxy:               getEnv(prefix+"PROXY", def.Proxy),
+		MaxIdleConns:        getEnvInt(prefix+"MAX_IDLE_CONNS", def.MaxIdleConns),
+		IdleConnTimeout:     getEnvDuration(prefix+"IDLE_CONN_TIMEOUT", def.IdleConnTimeout),
+		DialTimeout:         getEnvDuration(prefix+"DIAL_TIMEOUT", def.DialTimeout),
+		TLSHandshakeTimeout: getEnvDuration(prefix+"TLS_HANDSHAKE_TIMEOUT", def.TLSHandshakeTimeout),
+	}
+}
+
+func (h HTTPConfig) validate(name string) []error {
+	var errs []error
+	if (h.ClientCert == "") != (h.ClientKey == "") {
+		errs = append(errs, fmt.Errorf("%s: client certificate and key must be set together", name))
+	}
+	if h.MaxIdleConns < 0 || h.IdleConnTimeout < 0 || h.DialTimeout < 0 || h.TLSHandshakeTimeout < 0 {
+		errs = append(errs, fmt.Errorf("%s: connection settings must not be negative", name))
+	}
+	return errs
+}
+
+// loadCorrelation reads the alert correlation settings from variables
+// starting with prefix, falling back to def.
+func loadCorrelation(prefix string, def CorrelationConfig) CorrelationConfig {
+	return CorrelationConfig{
+		Key:              getEnv(prefix+"CORRELATION_KEY", def.Key),
+		ResolvedPattern:  getEnv(prefix+"RESOLVED_PATTERN", def.ResolvedPattern),
+		ResolvedNote:     getEnvBool(prefix+"RESOLVED_NOTE", def.ResolvedNote),
+		ResolvedPriority: getEnvInt(prefix+"RESOLVED_PRIORITY", def.ResolvedPriority),
+		Supersede:        getEnvBool(prefix+"SUPERSEDE", def.Supersede),
+		TTL:              getEnvDuration(prefix+"CORRELATION_TTL", def.TTL),
+	}
+}
+
+func (c CorrelationConfig) validate(name, clientToken string) []error {
+	if c.Key == "" {
+		return nil
+	}
+	var errs []error
+	if clientToken == "" {
+		errs = append(errs, fmt.Errorf("%s: correlation needs a client token to delete notifications", name))
+	}
+	if _, err := regexp.Compile(c.ResolvedPattern); err != nil {
+		errs = append(errs, fmt.Errorf("%s: invalid resolved pattern: %w", name, err))
+	}
+	if c.ResolvedPriority < 0 || c.ResolvedPriority > 10 {
+		errs = append(errs, fmt.Errorf("%s: resolved priority must be between 0 and 10, got %d", name, c.ResolvedPriority))
+	}
+	if c.TTL <= 0 {
+		errs = append(errs, fmt.Errorf("%s: correlation TTL must be positive, got %s", name, c.TTL))
+	}
+	return errs
+}
+
+// loadProvision reads the application provisioning settings from variables
+// starting with prefix, falling back to def.
+func loadProvision(prefix string, def ProvisionConfig) ProvisionConfig {
+	allow := parseTokens(getEnv(prefix+"PROVISION_ALLOW", ""))
+	if allow == nil {
+		allow = def.Allow
+	}
+	return ProvisionConfig{
+		By:          getEnv(prefix+"PROVISION", def.By),
+		Allow:       allow,
+		MaxApps:     getEnvInt(prefix+"PROVISION_MAX_APPS", def.MaxApps),
+		NamePrefix:  getEnv(prefix+"PROVISION_NAME_PREFIX", def.NamePrefix),
+		Description: getEnv(prefix+"PROVISION_DESCRIPTION", def.Description),
+		Icon:        getEnv(prefix+"PROVISION_ICON", def.Icon),
+	}
+}
+
+func (p ProvisionConfig) validate(name, clientToken string) []error {
+	var errs []error
+	switch p.By {
+	case "":
+		return nil
+	case "sender-domain", "user", "recipient":
+	default:
+		errs = append(errs, fmt.Errorf("%s: provisioning must be one of sender-domain/user/recipient, got %s", name, p.By))
+	}
+	if p.MaxApps < 0 {
+		errs = append(errs, fmt.Errorf("%s: provisioning application limit must not be negative, got %d", name, p.MaxApps))
+	}
+	if clientToken == "" {
+		errs = append(errs, fmt.Errorf("%s: provisioning needs a client token to create applications", name))
+	}
+	return errs
+}
+
+// defaultDestinations returns GOTIFY_DEFAULT_DESTINATIONS, falling back to
+// the default server if GOTIFY_URL is set and to all named destinations
+// otherwise.
+func defaultDestinations(c *Config) []string {
+	if names := parseTokens(getEnv("GOTIFY_DEFAULT_DESTINATIONS", "")); len(names) > 0 {
+		return names
+	}
+	if c.Gotify.URL != "" {
+		return []string{"default"}
+	}
+	var names []string
+	for _, d := range c.Gotify.Destinations {
+		names = append(names, d.Name)
+	}
+	for _, w := range c.Webhooks {
+		names = append(names, w.Name)
+	}
+	for _, n := range c.Ntfy {
+		names = append(names, n.Name)
+	}
+	return names
+}
+
+// envName turns an arbitrary name into an environment variable fragment,
+// e.g. "nas.home" becomes "NAS_HOME".
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -124,6 +944,15 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
+
 func getEnvBool(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if b, err := strconv.ParseBool(value); err == nil {
@@ -133,6 +962,18 @@ func getEnvBool(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+// parseLines splits s into its non-empty lines, for values that may contain
+// commas.
+func parseLines(s string) []string {
+	var lines []string
+	for _, l := range strings.Split(s, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
 func parseTokens(s string) []string {
 	if s == "" {
 		return nil
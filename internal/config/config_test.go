@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
@@ -69,6 +70,219 @@ func TestLoadMultipleTokens(t *testing.T) {
 	}
 }
 
+func TestLoadUsers(t *testing.T) {
+	os.Setenv("GOTIFY_URL", "http://localhost:8080")
+	os.Setenv("GOTIFY_TOKEN", "global")
+	os.Setenv("GOTIFY_USERS", "printer, nas.home")
+	os.Setenv("GOTIFY_USER_PRINTER_TOKEN", "printer-token")
+	os.Setenv("GOTIFY_USER_PRINTER_PRIORITY", "8")
+	os.Setenv("GOTIFY_USER_NAS_HOME_TITLE_TEMPLATE", "NAS: {{.Subject}}")
+	defer func() {
+		os.Unsetenv("GOTIFY_URL")
+		os.Unsetenv("GOTIFY_TOKEN")
+		os.Unsetenv("GOTIFY_USERS")
+		os.Unsetenv("GOTIFY_USER_PRINTER_TOKEN")
+		os.Unsetenv("GOTIFY_USER_PRINTER_PRIORITY")
+		os.Unsetenv("GOTIFY_USER_NAS_HOME_TITLE_TEMPLATE")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(cfg.Gotify.Users) != 2 {
+		t.Fatalf("expected 2 users, got %d", len(cfg.Gotify.Users))
+	}
+
+	printer := cfg.Gotify.Users[0]
+	if printer.Name != "printer" || printer.Tokens[0] != "printer-token" || printer.Priority != 8 {
+		t.Errorf("unexpected printer config: %+v", printer)
+	}
+
+	nas := cfg.Gotify.Users[1]
+	if nas.Tokens[0] != "global" || nas.Priority != 5 {
+		t.Errorf("expected nas to inherit global settings, got %+v", nas)
+	}
+	if nas.TitleTemplate != "NAS: {{.Subject}}" {
+		t.Errorf("expected nas title template override, got %s", nas.TitleTemplate)
+	}
+}
+
+func TestLoadDestinations(t *testing.T) {
+	os.Setenv("GOTIFY_PRIORITY", "3")
+	os.Setenv("GOTIFY_DESTINATIONS", "prod, home.lab")
+	os.Setenv("GOTIFY_DESTINATION_PROD_URL", "https://gotify.example.com")
+	os.Setenv("GOTIFY_DESTINATION_PROD_TOKEN", "prod-token")
+	os.Setenv("GOTIFY_DESTINATION_HOME_LAB_URL", "http://gotify.home.lab")
+	os.Setenv("GOTIFY_DESTINATION_HOME_LAB_TOKEN", "home-a,home-b")
+	os.Setenv("GOTIFY_DESTINATION_HOME_LAB_PRIORITY", "8")
+	os.Setenv("GOTIFY_USERS", "printer")
+	os.Setenv("GOTIFY_USER_PRINTER_DESTINATIONS", "home.lab")
+	defer func() {
+		for _, key := range []string{
+			"GOTIFY_PRIORITY", "GOTIFY_DESTINATIONS",
+			"GOTIFY_DESTINATION_PROD_URL", "GOTIFY_DESTINATION_PROD_TOKEN",
+			"GOTIFY_DESTINATION_HOME_LAB_URL", "GOTIFY_DESTINATION_HOME_LAB_TOKEN", "GOTIFY_DESTINATION_HOME_LAB_PRIORITY",
+			"GOTIFY_USERS", "GOTIFY_USER_PRINTER_DESTINATIONS",
+		} {
+			os.Unsetenv(key)
+		}
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(cfg.Gotify.Destinations) != 2 {
+		t.Fatalf("expected 2 destinations, got %d", len(cfg.Gotify.Destinations))
+	}
+	prod, home := cfg.Gotify.Destinations[0], cfg.Gotify.Destinations[1]
+	if prod.URL != "https://gotify.example.com" || prod.Priority != 3 {
+		t.Errorf("unexpected prod destination: %+v", prod)
+	}
+	if len(home.Tokens) != 2 || home.Priority != 8 {
+		t.Errorf("unexpected home.lab destination: %+v", home)
+	}
+
+	// Without GOTIFY_URL all named destinations are the default
+	if len(cfg.Gotify.DefaultDestinations) != 2 {
+		t.Errorf("expected both destinations as default, got %v", cfg.Gotify.DefaultDestinations)
+	}
+	if got := cfg.Gotify.Users[0].Destinations; len(got) != 1 || got[0] != "home.lab" {
+		t.Errorf("expected printer to route to home.lab, got %v", got)
+	}
+}
+
+func TestLoadWebhooks(t *testing.T) {
+	os.Setenv("GOTIFY_URL", "http://gotify.local")
+	os.Setenv("GOTIFY_TOKEN", "tok")
+	os.Setenv("WEBHOOK_DESTINATIONS", "tickets")
+	os.Setenv("WEBHOOK_DESTINATION_TICKETS_URL", "https://tickets.example.com/api/issues")
+	os.Setenv("WEBHOOK_DESTINATION_TICKETS_METHOD", "put")
+	os.Setenv("WEBHOOK_DESTINATION_TICKETS_HEADERS", "X-Source: smtp\nX-Tags: mail, alerts\n")
+	os.Setenv("GOTIFY_DEFAULT_DESTINATIONS", "default,tickets")
+	defer func() {
+		for _, key := range []string{
+			"GOTIFY_URL", "GOTIFY_TOKEN", "WEBHOOK_DESTINATIONS", "WEBHOOK_DESTINATION_TICKETS_URL",
+			"WEBHOOK_DESTINATION_TICKETS_METHOD", "WEBHOOK_DESTINATION_TICKETS_HEADERS", "GOTIFY_DEFAULT_DESTINATIONS",
+		} {
+			os.Unsetenv(key)
+		}
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(cfg.Webhooks) != 1 {
+		t.Fatalf("expected 1 webhook, got %d", len(cfg.Webhooks))
+	}
+	w := cfg.Webhooks[0]
+	if w.Method != "put" || w.Timeout != 30*time.Second {
+		t.Errorf("unexpected webhook: %+v", w)
+	}
+	if len(w.Headers) != 2 || w.Headers[1] != "X-Tags: mail, alerts" {
+		t.Errorf("unexpected headers: %q", w.Headers)
+	}
+}
+
+func TestLoadNtfy(t *testing.T) {
+	os.Setenv("GOTIFY_PRIORITY", "8")
+	os.Setenv("NTFY_DESTINATIONS", "team")
+	os.Setenv("NTFY_DESTINATION_TEAM_TOPIC", "alerts, backups")
+	os.Setenv("NTFY_DESTINATION_TEAM_TAGS", "email")
+	defer func() {
+		for _, key := range []string{
+			"GOTIFY_PRIORITY", "NTFY_DESTINATIONS", "NTFY_DESTINATION_TEAM_TOPIC", "NTFY_DESTINATION_TEAM_TAGS",
+		} {
+			os.Unsetenv(key)
+		}
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(cfg.Ntfy) != 1 {
+		t.Fatalf("expected 1 ntfy destination, got %d", len(cfg.Ntfy))
+	}
+	n := cfg.Ntfy[0]
+	if n.URL != "https://ntfy.sh" || len(n.Topics) != 2 || n.Priority != 8 || n.TitleTemplate != "{{.Subject}}" {
+		t.Errorf("unexpected ntfy destination: %+v", n)
+	}
+	// Without GOTIFY_URL it is the only default destination
+	if len(cfg.Gotify.DefaultDestinations) != 1 || cfg.Gotify.DefaultDestinations[0] != "team" {
+		t.Errorf("expected team as default, got %v", cfg.Gotify.DefaultDestinations)
+	}
+}
+
+func TestLoadListeners(t *testing.T) {
+	os.Setenv("GOTIFY_URL", "http://localhost:8080")
+	os.Setenv("GOTIFY_TOKEN", "test-token")
+	os.Setenv("SMTP_TLS_CERT", "/certs/tls.crt")
+	os.Setenv("SMTP_TLS_KEY", "/certs/tls.key")
+	os.Setenv("SMTP_AUTH_USERS", "printer:plain:secret")
+	os.Setenv("SMTP_LISTENERS", "lan,smtps,local")
+	os.Setenv("SMTP_LISTENER_LAN_ADDR", ":2525")
+	os.Setenv("SMTP_LISTENER_SMTPS_ADDR", ":465")
+	os.Setenv("SMTP_LISTENER_SMTPS_TLS", "implicit")
+	os.Setenv("SMTP_LISTENER_SMTPS_AUTH_REQUIRED", "true")
+	os.Setenv("SMTP_LISTENER_LOCAL_NETWORK", "unix")
+	os.Setenv("SMTP_LISTENER_LOCAL_ADDR", "/run/smtp-gotify.sock")
+	defer func() {
+		for _, key := range []string{
+			"GOTIFY_URL", "GOTIFY_TOKEN", "SMTP_TLS_CERT", "SMTP_TLS_KEY", "SMTP_AUTH_USERS", "SMTP_LISTENERS",
+			"SMTP_LISTENER_LAN_ADDR", "SMTP_LISTENER_SMTPS_ADDR", "SMTP_LISTENER_SMTPS_TLS",
+			"SMTP_LISTENER_SMTPS_AUTH_REQUIRED", "SMTP_LISTENER_LOCAL_NETWORK", "SMTP_LISTENER_LOCAL_ADDR",
+		} {
+			os.Unsetenv(key)
+		}
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(cfg.SMTP.Listeners) != 3 {
+		t.Fatalf("expected 3 listeners, got %d", len(cfg.SMTP.Listeners))
+	}
+
+	smtps := cfg.SMTP.Listeners[1]
+	if smtps.TLS != "implicit" || !smtps.AuthRequired || smtps.Addr != ":465" {
+		t.Errorf("unexpected smtps listener: %+v", smtps)
+	}
+
+	local := cfg.SMTP.Listeners[2]
+	if local.Network != "unix" || local.TLS != "starttls" || local.AuthRequired {
+		t.Errorf("unexpected local listener: %+v", local)
+	}
+}
+
+func TestLoadDefaultListener(t *testing.T) {
+	os.Setenv("GOTIFY_URL", "http://localhost:8080")
+	os.Setenv("GOTIFY_TOKEN", "test-token")
+	os.Setenv("SMTP_LISTEN", ":2526")
+	defer func() {
+		os.Unsetenv("GOTIFY_URL")
+		os.Unsetenv("GOTIFY_TOKEN")
+		os.Unsetenv("SMTP_LISTEN")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(cfg.SMTP.Listeners) != 1 || cfg.SMTP.Listeners[0].Addr != ":2526" {
+		t.Errorf("expected default listener on :2526, got %+v", cfg.SMTP.Listeners)
+	}
+}
+
 func TestValidate(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -93,6 +307,28 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "implicit TLS without certificate",
+			cfg: Config{
+				Gotify: GotifyConfig{URL: "http://example.com", Tokens: []string{"tok"}, Priority: 5},
+				SMTP: SMTPConfig{MaxSize: 1000, Listeners: []ListenerConfig{
+					{Name: "smtps", Network: "tcp", Addr: ":465", TLS: "implicit"},
+				}},
+				Log: LogConfig{Level: "info", Format: "json"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "auth required without users",
+			cfg: Config{
+				Gotify: GotifyConfig{URL: "http://example.com", Tokens: []string{"tok"}, Priority: 5},
+				SMTP: SMTPConfig{MaxSize: 1000, Listeners: []ListenerConfig{
+					{Name: "lan", Network: "tcp", Addr: ":2525", TLS: "none", AuthRequired: true},
+				}},
+				Log: LogConfig{Level: "info", Format: "json"},
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid log level",
 			cfg: Config{
@@ -102,6 +338,82 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "route with unknown destination",
+			cfg: Config{
+				Gotify: GotifyConfig{
+					URL: "http://example.com", Tokens: []string{"tok"}, Priority: 5,
+					Users: []RouteConfig{{Name: "printer", Priority: 5, Destinations: []string{"missing"}}},
+				},
+				SMTP: SMTPConfig{MaxSize: 1000},
+				Log:  LogConfig{Level: "info", Format: "json"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "spool retry min above max",
+			cfg: Config{
+				Gotify: GotifyConfig{URL: "http://example.com", Tokens: []string{"tok"}, Priority: 5},
+				SMTP:   SMTPConfig{MaxSize: 1000},
+				Spool:  SpoolConfig{Dir: "/var/spool/smtp-gotify", MinBackoff: time.Hour, MaxBackoff: time.Minute},
+				Log:    LogConfig{Level: "info", Format: "json"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid rate limit",
+			cfg: Config{
+				Gotify: GotifyConfig{URL: "http://example.com", Tokens: []string{"tok"}, Priority: 5},
+				SMTP:   SMTPConfig{MaxSize: 1000, RateLimitSender: "10 per minute"},
+				Log:    LogConfig{Level: "info", Format: "json"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown oversize strategy",
+			cfg: Config{
+				Gotify: GotifyConfig{URL: "http://example.com", Tokens: []string{"tok"}, Priority: 5, MaxMessageSize: 4096, OversizeStrategy: "drop"},
+				SMTP:   SMTPConfig{MaxSize: 1000},
+				Log:    LogConfig{Level: "info", Format: "json"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "correlation without client token",
+			cfg: Config{
+				Gotify: GotifyConfig{
+					URL: "http://example.com", Tokens: []string{"tok"}, Priority: 5,
+					Correlation: CorrelationConfig{Key: "{{.Subject}}", ResolvedPattern: "RESOLVED", TTL: time.Hour},
+				},
+				SMTP: SMTPConfig{MaxSize: 1000},
+				Log:  LogConfig{Level: "info", Format: "json"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown provisioning key",
+			cfg: Config{
+				Gotify: GotifyConfig{
+					URL: "http://example.com", Tokens: []string{"tok"}, Priority: 5, ClientToken: "client",
+					Provision: ProvisionConfig{By: "hostname"},
+				},
+				SMTP: SMTPConfig{MaxSize: 1000},
+				Log:  LogConfig{Level: "info", Format: "json"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative provisioning limit",
+			cfg: Config{
+				Gotify: GotifyConfig{
+					URL: "http://example.com", Tokens: []string{"tok"}, Priority: 5, ClientToken: "client",
+					Provision: ProvisionConfig{By: "user", MaxApps: -1},
+				},
+				SMTP: SMTPConfig{MaxSize: 1000},
+				Log:  LogConfig{Level: "info", Format: "json"},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,216 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/alex/smtp-gotify/internal/mail"
+)
+
+// CircuitOpenError is returned without contacting the destination while its
+// circuit breaker is open. It is temporary so senders and the spool retry.
+type CircuitOpenError struct {
+	Destination string
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("destination %s unavailable, circuit open", e.Destination)
+}
+
+func (e *CircuitOpenError) Temporary() bool {
+	return true
+}
+
+type breakerState int
+
+const (
+	stateClosed breakerState = iota
+	stateOpen
+	stateHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case stateOpen:
+		return "open"
+	case stateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Breaker stops sending to a destination after threshold consecutive
+// temporary failures. While open it fails fast; after each cooldown one
+// message is let through as a trial, and probe, if set, is called in the
+// background to detect recovery without waiting for traffic.
+type Breaker struct {
+	// ctx ends background probing when the server shuts down
+	ctx       context.Context
+	name      string
+	next      Forwarder
+	threshold int
+	cooldown  time.Duration
+	probe     func(ctx context.Context) error
+	logger    *slog.Logger
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	trial    bool
+	probing  bool
+}
+
+// NewBreaker creates a breaker for the destination next. Probing stops when
+// ctx is done.
+func NewBreaker(ctx context.Context, name string, next Forwarder, threshold int, cooldown time.Duration, probe func(ctx context.Context) error, logger *slog.Logger) *Breaker {
+	return &Breaker{
+		ctx:       ctx,
+		name:      name,
+		next:      next,
+		threshold: threshold,
+		cooldown:  cooldown,
+		probe:     probe,
+		logger:    logger,
+	}
+}
+
+func (b *Breaker) Forward(ctx context.Context, msg *mail.Message) error {
+	trial, ok := b.allow()
+	if !ok {
+		return &CircuitOpenError{Destination: b.name}
+	}
+	err := b.next.Forward(ctx, msg)
+	b.record(ctx, err, trial)
+	return err
+}
+
+// State returns closed, open or half-open.
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state.String()
+}
+
+// Healthy reports whether the circuit is closed.
+func (b *Breaker) Healthy() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == stateClosed
+}
+
+// allow reports whether a call may go through and whether it is the trial
+// call, which must be passed back to record.
+func (b *Breaker) allow() (trial, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case stateOpen:
+		if time.Since(b.openedAt) < b.cooldown || b.trial {
+			return false, false
+		}
+		b.state = stateHalfOpen
+		b.trial = true
+		b.logger.Info("circuit half-open, sending trial message", "destination", b.name)
+		return true, true
+	case stateHalfOpen:
+		if b.trial {
+			return false, false
+		}
+		b.trial = true
+		return true, true
+	}
+	return false, true
+}
+
+// record updates the circuit with the result of a call. Only the trial call
+// releases the trial; calls that started before the circuit opened do not.
+func (b *Breaker) record(ctx context.Context, err error, trial bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if trial {
+		b.trial = false
+	}
+
+	// Cancelled on shutdown, which says nothing about the destination. An
+	// expired deadline does count: a destination that does not answer
+	// runs every call past it.
+	if err != nil && (b.ctx.Err() != nil || errors.Is(ctx.Err(), context.Canceled)) {
+		if b.state == stateHalfOpen {
+			b.state = stateOpen
+		}
+		return
+	}
+
+	// Rejections and partial deliveries show the server is reachable
+	var partial interface{ Partial() bool }
+	if err == nil || Permanent(err) || (errors.As(err, &partial) && partial.Partial()) {
+		b.closeLocked()
+		return
+	}
+
+	b.failures++
+	if b.state == stateHalfOpen || b.failures >= b.threshold {
+		if b.state == stateClosed {
+			b.logger.Warn("circuit opened", "destination", b.name, "failures", b.failures, "error", err)
+		}
+		b.state = stateOpen
+		b.openedAt = time.Now()
+		if b.probe != nil && !b.probing {
+			b.probing = true
+			go b.runProbe()
+		}
+	}
+}
+
+func (b *Breaker) closeLocked() {
+	if b.state != stateClosed {
+		b.logger.Info("circuit closed", "destination", b.name)
+	}
+	b.state = stateClosed
+	b.failures = 0
+}
+
+// runProbe calls probe every cooldown until the destination answers, the
+// circuit closes through regular traffic or the breaker's context is done.
+func (b *Breaker) runProbe() {
+	ticker := time.NewTicker(b.cooldown)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.ctx.Done():
+			b.mu.Lock()
+			b.probing = false
+			b.mu.Unlock()
+			return
+		case <-ticker.C:
+		}
+
+		b.mu.Lock()
+		closed := b.state == stateClosed
+		b.mu.Unlock()
+		if !closed {
+			ctx, cancel := context.WithTimeout(b.ctx, b.cooldown)
+			err := b.probe(ctx)
+			cancel()
+			if err != nil {
+				b.logger.Debug("destination probe failed", "destination", b.name, "error", err)
+				continue
+			}
+		}
+
+		b.mu.Lock()
+		b.closeLocked()
+		b.probing = false
+		b.mu.Unlock()
+		return
+	}
+}
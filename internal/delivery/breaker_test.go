@@ -0,0 +1,272 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alex/smtp-gotify/internal/mail"
+)
+
+type switchForwarder struct {
+	mu    sync.Mutex
+	err   error
+	calls int
+}
+
+func (f *switchForwarder) Forward(ctx context.Context, msg *mail.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return f.err
+}
+
+func (f *switchForwarder) set(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *switchForwarder) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func TestBreaker_OpensAndRecovers(t *testing.T) {
+	next := &switchForwarder{err: errors.New("connection refused")}
+	b := NewBreaker(context.Background(), "prod", next, 2, 20*time.Millisecond, nil, slog.Default())
+	ctx := context.Background()
+	msg := &mail.Message{}
+
+	_ = b.Forward(ctx, msg)
+	if b.State() != "closed" {
+		t.Fatalf("expected closed after one failure, got %s", b.State())
+	}
+	_ = b.Forward(ctx, msg)
+	if b.State() != "open" || b.Healthy() {
+		t.Fatalf("expected open after threshold, got %s", b.State())
+	}
+
+	// Fails fast without calling the destination
+	err := b.Forward(ctx, msg)
+	var openErr *CircuitOpenError
+	if !errors.As(err, &openErr) || Permanent(err) {
+		t.Fatalf("expected temporary circuit open error, got %v", err)
+	}
+	if next.count() != 2 {
+		t.Errorf("expected 2 calls to the destination, got %d", next.count())
+	}
+
+	// After the cooldown one trial goes through and closes the circuit
+	time.Sleep(30 * time.Millisecond)
+	next.set(nil)
+	if err := b.Forward(ctx, msg); err != nil {
+		t.Fatalf("unexpected error on trial: %v", err)
+	}
+	if b.State() != "closed" {
+		t.Errorf("expected closed after successful trial, got %s", b.State())
+	}
+}
+
+func TestBreaker_FailedTrialReopens(t *testing.T) {
+	next := &switchForwarder{err: errors.New("timeout")}
+	b := NewBreaker(context.Background(), "prod", next, 1, 10*time.Millisecond, nil, slog.Default())
+
+	_ = b.Forward(context.Background(), &mail.Message{})
+	time.Sleep(20 * time.Millisecond)
+	_ = b.Forward(context.Background(), &mail.Message{})
+
+	if b.State() != "open" {
+		t.Errorf("expected open after failed trial, got %s", b.State())
+	}
+	if next.count() != 2 {
+		t.Errorf("expected trial to reach the destination, got %d calls", next.count())
+	}
+}
+
+// hangingForwarder blocks until the caller gives up, like a destination that
+// drops packets.
+type hangingForwarder struct{}
+
+func (hangingForwarder) Forward(ctx context.Context, msg *mail.Message) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestBreaker_DeadlineOpens(t *testing.T) {
+	b := NewBreaker(context.Background(), "prod", hangingForwarder{}, 2, time.Minute, nil, slog.Default())
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_ = b.Forward(ctx, &mail.Message{})
+		cancel()
+	}
+	if b.State() != "open" {
+		t.Errorf("expected timeouts to open the circuit, got %s", b.State())
+	}
+}
+
+func TestBreaker_CancelDoesNotCount(t *testing.T) {
+	b := NewBreaker(context.Background(), "prod", hangingForwarder{}, 1, time.Minute, nil, slog.Default())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = b.Forward(ctx, &mail.Message{})
+	if b.State() != "closed" {
+		t.Errorf("expected a cancelled call not to count, got %s", b.State())
+	}
+}
+
+func TestBreaker_PermanentErrorsDoNotOpen(t *testing.T) {
+	next := &switchForwarder{err: permanentError{}}
+	b := NewBreaker(context.Background(), "prod", next, 1, time.Minute, nil, slog.Default())
+
+	for i := 0; i < 3; i++ {
+		_ = b.Forward(context.Background(), &mail.Message{})
+	}
+	if b.State() != "closed" {
+		t.Errorf("expected rejected messages to keep the circuit closed, got %s", b.State())
+	}
+}
+
+func TestBreaker_Probe(t *testing.T) {
+	next := &switchForwarder{err: errors.New("connection refused")}
+	var probeMu sync.Mutex
+	probeErr := errors.New("still down")
+	probe := func(ctx context.Context) error {
+		probeMu.Lock()
+		defer probeMu.Unlock()
+		return probeErr
+	}
+	b := NewBreaker(context.Background(), "prod", next, 1, 10*time.Millisecond, probe, slog.Default())
+
+	_ = b.Forward(context.Background(), &mail.Message{})
+	if b.State() != "open" {
+		t.Fatalf("expected open, got %s", b.State())
+	}
+
+	probeMu.Lock()
+	probeErr = nil
+	probeMu.Unlock()
+
+	deadline := time.Now().Add(time.Second)
+	for b.State() != "closed" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if b.State() != "closed" {
+		t.Errorf("expected successful probe to close the circuit, got %s", b.State())
+	}
+	if next.count() != 1 {
+		t.Errorf("expected probe to close the circuit without traffic, got %d calls", next.count())
+	}
+}
+
+// gatedForwarder blocks each message until a result is sent on the gate
+// named by its subject.
+type gatedForwarder struct {
+	started chan string
+	gates   map[string]chan error
+}
+
+func (f *gatedForwarder) Forward(ctx context.Context, msg *mail.Message) error {
+	f.started <- msg.Subject
+	return <-f.gates[msg.Subject]
+}
+
+func TestBreaker_StaleCallKeepsTrial(t *testing.T) {
+	next := &gatedForwarder{
+		started: make(chan string, 4),
+		gates: map[string]chan error{
+			"stale": make(chan error, 1),
+			"fail":  make(chan error, 1),
+			"trial": make(chan error, 1),
+			"late":  make(chan error, 1),
+		},
+	}
+	b := NewBreaker(context.Background(), "prod", next, 1, 10*time.Millisecond, nil, slog.Default())
+
+	// A call that started while the circuit was closed
+	staleCtx, cancel := context.WithCancel(context.Background())
+	staleDone := make(chan struct{})
+	go func() {
+		_ = b.Forward(staleCtx, &mail.Message{Subject: "stale"})
+		close(staleDone)
+	}()
+	<-next.started
+
+	next.gates["fail"] <- errors.New("connection refused")
+	_ = b.Forward(context.Background(), &mail.Message{Subject: "fail"})
+	<-next.started
+	if b.State() != "open" {
+		t.Fatalf("expected open, got %s", b.State())
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	trialDone := make(chan error, 1)
+	go func() {
+		trialDone <- b.Forward(context.Background(), &mail.Message{Subject: "trial"})
+	}()
+	<-next.started
+
+	// The stale call finishing must not let a second trial through
+	cancel()
+	next.gates["stale"] <- context.Canceled
+	<-staleDone
+
+	next.gates["late"] <- nil
+	var openErr *CircuitOpenError
+	if err := b.Forward(context.Background(), &mail.Message{Subject: "late"}); !errors.As(err, &openErr) {
+		t.Errorf("expected circuit open error while the trial is in flight, got %v", err)
+	}
+
+	next.gates["trial"] <- nil
+	if err := <-trialDone; err != nil {
+		t.Fatalf("unexpected error on trial: %v", err)
+	}
+	if b.State() != "closed" {
+		t.Errorf("expected closed after successful trial, got %s", b.State())
+	}
+}
+
+func TestBreaker_ProbeStops(t *testing.T) {
+	next := &switchForwarder{err: errors.New("connection refused")}
+	var mu sync.Mutex
+	calls := 0
+	probe := func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return errors.New("still down")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	b := NewBreaker(ctx, "prod", next, 1, 5*time.Millisecond, probe, slog.Default())
+
+	_ = b.Forward(context.Background(), &mail.Message{})
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		b.mu.Lock()
+		probing := b.probing
+		b.mu.Unlock()
+		if !probing {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mu.Lock()
+	stopped := calls
+	mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if stopped == 0 || calls != stopped {
+		t.Errorf("expected probing to stop with the context, got %d then %d probes", stopped, calls)
+	}
+}
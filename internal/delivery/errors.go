@@ -0,0 +1,136 @@
+package delivery
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Permanent reports whether retrying err cannot succeed. Errors say so by
+// implementing Temporary; a joined error is permanent only if all of its
+// parts are. Unclassified errors are assumed to be temporary.
+func Permanent(err error) bool {
+	switch e := err.(type) {
+	case interface{ Temporary() bool }:
+		return !e.Temporary()
+	case interface{ Unwrap() []error }:
+		errs := e.Unwrap()
+		for _, err := range errs {
+			if !Permanent(err) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	case interface{ Unwrap() error }:
+		return Permanent(e.Unwrap())
+	}
+	return false
+}
+
+// TemplateError is returned when a message cannot be rendered. Retrying
+// renders the same message with the same templates, so it is permanent.
+type TemplateError struct {
+	Err error
+}
+
+func (e *TemplateError) Error() string {
+	return "render template: " + e.Err.Error()
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TemplateError) Temporary() bool {
+	return false
+}
+
+// StatusError is returned when a destination answers with a non-2xx status.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	// RetryAfter is the delay requested by a 429 or 503 response, if any.
+	RetryAfter time.Duration
+}
+
+// NewStatusError returns the error for resp, including its Retry-After.
+func NewStatusError(resp *http.Response) *StatusError {
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+func (e *StatusError) Error() string {
+	return "unexpected status: " + e.Status
+}
+
+// Temporary reports whether retrying may succeed. Other 4xx responses, such
+// as an invalid token, fail the same way every time.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests
+}
+
+// RequestError is returned when a destination could not be reached at all.
+// It is always worth retrying, unlike what *url.Error.Temporary suggests
+// for refused connections.
+type RequestError struct {
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RequestError) Temporary() bool {
+	return true
+}
+
+// Redact strips credentials from the URL carried by a *url.Error, so they
+// do not end up in logs or SMTP replies.
+func Redact(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+	return &url.Error{Op: urlErr.Op, URL: RedactURL(urlErr.URL), Err: urlErr.Err}
+}
+
+// RedactURL masks the user info and every query value of raw. Tokens and
+// webhook secrets are commonly passed in either.
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "[invalid URL]"
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		for key := range q {
+			q.Set(key, "xxxxx")
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.Redacted()
+}
+
+// parseRetryAfter accepts both forms of the Retry-After header: a number of
+// seconds or an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return max(time.Duration(secs)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
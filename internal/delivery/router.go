@@ -0,0 +1,180 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/alex/smtp-gotify/internal/mail"
+)
+
+type Forwarder interface {
+	Forward(ctx context.Context, msg *mail.Message) error
+}
+
+// Error reports that a message did not reach all of its destinations.
+type Error struct {
+	Delivered int
+	Total     int
+	Err       error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("failed to deliver to %d/%d destinations: %v", e.Total-e.Delivered, e.Total, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Partial reports whether the message reached at least one destination, in
+// which case a retry should only target the others.
+func (e *Error) Partial() bool {
+	if e.Delivered > 0 {
+		return true
+	}
+	var partial interface{ Partial() bool }
+	return errors.As(e.Err, &partial) && partial.Partial()
+}
+
+// Router forwards each message to one or more named destinations, chosen by
+// its route: an explicit route on the message, then the authenticated user,
+// then the envelope recipients. Destinations receive the message with Route
+// set to the resolved route name, so they do not repeat the lookup.
+type Router struct {
+	destinations map[string]Forwarder
+	defaults     []string
+	users        map[string][]string
+	recipients   map[string][]string
+}
+
+// NewRouter creates a router. users and recipients map route names to their
+// destinations; a route without destinations uses the defaults.
+func NewRouter(destinations map[string]Forwarder, defaults []string, users, recipients map[string][]string) *Router {
+	rcpt := make(map[string][]string, len(recipients))
+	for key, names := range recipients {
+		rcpt[strings.ToLower(key)] = names
+	}
+	return &Router{
+		destinations: destinations,
+		defaults:     defaults,
+		users:        users,
+		recipients:   rcpt,
+	}
+}
+
+// HasRoute reports whether name is a destination or a configured route.
+func (r *Router) HasRoute(name string) bool {
+	if _, ok := r.destinations[name]; ok {
+		return true
+	}
+	if _, ok := r.users[name]; ok {
+		return true
+	}
+	_, ok := r.recipients[strings.ToLower(name)]
+	return ok
+}
+
+func (r *Router) Forward(ctx context.Context, msg *mail.Message) error {
+	route, names := r.route(msg)
+
+	orig := msg
+	routed := *msg
+	routed.Route = route
+	defer func() { orig.Delivered = routed.Delivered }()
+	msg = &routed
+
+	if len(names) == 1 {
+		return r.destinations[names[0]].Forward(ctx, msg)
+	}
+
+	// Each destination gets its own copy so delivery state can be recorded
+	// concurrently and merged afterwards
+	copies := make([]mail.Message, len(names))
+	results := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		copies[i] = *msg
+		copies[i].Delivered = slices.Clone(msg.Delivered)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = r.destinations[name].Forward(ctx, &copies[i])
+		}()
+	}
+	wg.Wait()
+
+	var errs []error
+	for i, name := range names {
+		for _, dest := range copies[i].Delivered {
+			if !slices.Contains(msg.Delivered, dest) {
+				msg.Delivered = append(msg.Delivered, dest)
+			}
+		}
+		if results[i] != nil {
+			errs = append(errs, fmt.Errorf("destination %s: %w", name, results[i]))
+		}
+	}
+
+	if len(errs) > 0 {
+		return &Error{
+			Delivered: len(names) - len(errs),
+			Total:     len(names),
+			Err:       errors.Join(errs...),
+		}
+	}
+	return nil
+}
+
+// Match returns the name of the user or recipient route msg belongs to: the
+// route named by msg.Route, then the authenticated user, then the first
+// envelope recipient with a route. It returns "" if none matches.
+func (r *Router) Match(msg *mail.Message) string {
+	if msg.Route != "" {
+		if _, ok := r.users[msg.Route]; ok {
+			return msg.Route
+		}
+		if _, ok := r.recipients[strings.ToLower(msg.Route)]; ok {
+			return strings.ToLower(msg.Route)
+		}
+	}
+	if _, ok := r.users[msg.User]; ok && msg.User != "" {
+		return msg.User
+	}
+	for _, rcpt := range msg.Recipients {
+		addr := strings.ToLower(strings.Trim(rcpt, "<>"))
+		if _, ok := r.recipients[addr]; ok {
+			return addr
+		}
+		local, _, _ := strings.Cut(addr, "@")
+		if _, ok := r.recipients[local]; ok {
+			return local
+		}
+	}
+	return ""
+}
+
+// route returns the route matching msg and its destinations. A message
+// whose Route names a destination goes only there, keeping the route it
+// would get otherwise. A route without destinations uses the defaults.
+func (r *Router) route(msg *mail.Message) (string, []string) {
+	name := r.Match(msg)
+	if _, ok := r.destinations[msg.Route]; ok && msg.Route != "" {
+		return name, []string{msg.Route}
+	}
+	names, ok := r.users[name]
+	if !ok {
+		names = r.recipients[name]
+	}
+	return name, r.or(names)
+}
+
+func (r *Router) or(names []string) []string {
+	if len(names) > 0 {
+		return names
+	}
+	return r.defaults
+}
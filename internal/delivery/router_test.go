@@ -0,0 +1,107 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/alex/smtp-gotify/internal/mail"
+)
+
+type recordingForwarder struct {
+	mu    sync.Mutex
+	name  string
+	err   error
+	calls int
+	route string
+}
+
+func (f *recordingForwarder) Forward(ctx context.Context, msg *mail.Message) error {
+	f.mu.Lock()
+	f.calls++
+	f.route = msg.Route
+	f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	msg.Delivered = append(msg.Delivered, f.name)
+	return nil
+}
+
+func TestRouter_Route(t *testing.T) {
+	prod := &recordingForwarder{name: "prod"}
+	home := &recordingForwarder{name: "home"}
+	r := NewRouter(
+		map[string]Forwarder{"prod": prod, "home": home},
+		[]string{"prod"},
+		map[string][]string{"printer": {"home"}, "nas": nil},
+		map[string][]string{"Alerts@example.com": {"prod", "home"}, "lab": {"home"}},
+	)
+
+	tests := []struct {
+		name  string
+		msg   mail.Message
+		route string
+		want  []string
+	}{
+		{"default", mail.Message{}, "", []string{"prod"}},
+		{"user", mail.Message{User: "printer"}, "printer", []string{"home"}},
+		{"user without destinations", mail.Message{User: "nas", Recipients: []string{"lab@example.com"}}, "nas", []string{"prod"}},
+		{"recipient address", mail.Message{Recipients: []string{"<alerts@example.com>"}}, "alerts@example.com", []string{"prod", "home"}},
+		{"recipient local part", mail.Message{Recipients: []string{"lab@example.org"}}, "lab", []string{"home"}},
+		{"route names destination", mail.Message{Route: "home", User: "nas"}, "nas", []string{"home"}},
+		{"route names user route", mail.Message{Route: "printer"}, "printer", []string{"home"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, got := r.route(&tt.msg)
+			if route != tt.route {
+				t.Errorf("route() = %q, want %q", route, tt.route)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("route() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("route() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	msg := &mail.Message{Recipients: []string{"lab@example.org"}}
+	if err := r.Forward(context.Background(), msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if home.route != "lab" || msg.Route != "" {
+		t.Errorf("expected destination to get the resolved route without changing the message, got %q and %q", home.route, msg.Route)
+	}
+	if len(msg.Delivered) != 1 || msg.Delivered[0] != "home" {
+		t.Errorf("expected delivery to be recorded on the message, got %v", msg.Delivered)
+	}
+
+	if !r.HasRoute("home") || !r.HasRoute("ALERTS@example.com") || r.HasRoute("unknown") {
+		t.Error("unexpected HasRoute results")
+	}
+}
+
+func TestRouter_ForwardPartial(t *testing.T) {
+	prod := &recordingForwarder{name: "prod"}
+	home := &recordingForwarder{name: "home", err: errors.New("connection refused")}
+	r := NewRouter(map[string]Forwarder{"prod": prod, "home": home}, []string{"prod", "home"}, nil, nil)
+
+	msg := &mail.Message{Subject: "Test"}
+	err := r.Forward(context.Background(), msg)
+
+	var deliveryErr *Error
+	if !errors.As(err, &deliveryErr) || !deliveryErr.Partial() || deliveryErr.Delivered != 1 {
+		t.Fatalf("expected partial delivery error, got %v", err)
+	}
+	if len(msg.Delivered) != 1 || msg.Delivered[0] != "prod" {
+		t.Errorf("expected delivery to prod to be recorded, got %v", msg.Delivered)
+	}
+	if Permanent(err) {
+		t.Error("expected temporary error")
+	}
+}
@@ -0,0 +1,83 @@
+package delivery
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alex/smtp-gotify/internal/mail"
+)
+
+// SentTTL is how long forwarders remember where a message was delivered.
+// It covers the retry window of typical sending MTAs after a 451.
+const SentTTL = time.Hour
+
+// SentCache remembers the destinations each message reached, so a sender
+// retrying after a partial failure does not notify the same destination
+// twice. Messages are identified by ID and content hash, so a different
+// message reusing a Message-ID is delivered again.
+type SentCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	entries   map[string]*sentEntry
+	lastSweep time.Time
+}
+
+type sentEntry struct {
+	destinations map[string]bool
+	expires      time.Time
+}
+
+func NewSentCache(ttl time.Duration) *SentCache {
+	return &SentCache{
+		ttl:     ttl,
+		entries: make(map[string]*sentEntry),
+	}
+}
+
+// Has reports whether msg reached dest.
+func (c *SentCache) Has(msg *mail.Message, dest string) bool {
+	id := sentKey(msg)
+	if id == "" {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[id]
+	return ok && time.Now().Before(e.expires) && e.destinations[dest]
+}
+
+// Add records that msg reached dest.
+func (c *SentCache) Add(msg *mail.Message, dest string) {
+	id := sentKey(msg)
+	if id == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastSweep) > c.ttl {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	e, ok := c.entries[id]
+	if !ok || now.After(e.expires) {
+		e = &sentEntry{destinations: make(map[string]bool)}
+		c.entries[id] = e
+	}
+	e.destinations[dest] = true
+	e.expires = now.Add(c.ttl)
+}
+
+func sentKey(msg *mail.Message) string {
+	if msg.ID == "" {
+		return ""
+	}
+	return msg.ID + "\n" + msg.Hash
+}
@@ -3,13 +3,21 @@ package gotify
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/alex/smtp-gotify/internal/delivery"
 	"github.com/alex/smtp-gotify/internal/mail"
 	"github.com/alex/smtp-gotify/internal/template"
 )
@@ -21,14 +29,34 @@ type Message struct {
 	Extras   map[string]interface{} `json:"extras,omitempty"`
 }
 
+// Route selects the tokens, priority and templates used for a message.
+type Route struct {
+	Tokens   []string
+	Priority int
+	Renderer *template.Renderer
+}
+
 type Client struct {
 	baseURL  string
 	tokens   []string
 	priority int
 	markdown bool
 	renderer *template.Renderer
-	http     *http.Client
-	logger   *slog.Logger
+	routes   map[string]Route
+	rcptTo   map[string]Route
+	sent     *delivery.SentCache
+	retries  int
+	retryMin time.Duration
+	retryMax time.Duration
+	// concurrency bounds parallel requests per message
+	concurrency int
+	limit       Limit
+	clientToken string
+	correlation Correlation
+	alerts      *alertStore
+	provision   *provisioner
+	http        *http.Client
+	logger      *slog.Logger
 }
 
 type Config struct {
@@ -37,33 +65,105 @@ type Config struct {
 	Priority int
 	Markdown bool
 	Renderer *template.Renderer
-	Logger   *slog.Logger
+	// Routes maps user route names to their own delivery settings. The
+	// route of a message is resolved by delivery.Router and passed in
+	// mail.Message.Route.
+	Routes map[string]Route
+	// RecipientRoutes maps recipient route names, either a full address or
+	// a local part, to their own delivery settings.
+	RecipientRoutes map[string]Route
+	// Retries is the number of extra attempts per token after a network
+	// error, 429 or 5xx, waiting between RetryMin and RetryMax.
+	Retries  int
+	RetryMin time.Duration
+	RetryMax time.Duration
+	// Timeout bounds a single HTTP request, 0 means 30s.
+	Timeout time.Duration
+	// Concurrency is the number of tokens posted to in parallel, 0 means 4.
+	Concurrency int
+	// Transport, if set, is used for all requests, see NewTransport.
+	Transport http.RoundTripper
+	// Limit bounds the size of each notification body.
+	Limit Limit
+	// ClientToken is a Gotify client token, needed by Correlation and
+	// Provision to delete messages and create applications.
+	ClientToken string
+	// Correlation, when its Key is set, replaces earlier notifications of
+	// the same alert.
+	Correlation Correlation
+	// Provision, when its By is set, sends messages without a route to an
+	// application created per sending system.
+	Provision Provision
+	Logger    *slog.Logger
 }
 
 func NewClient(cfg Config) *Client {
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	alertTTL := cfg.Correlation.TTL
+	if alertTTL == 0 {
+		alertTTL = 7 * 24 * time.Hour
+	}
+
+	rcptTo := make(map[string]Route, len(cfg.RecipientRoutes))
+	for key, route := range cfg.RecipientRoutes {
+		rcptTo[strings.ToLower(key)] = route
+	}
+
 	return &Client{
-		baseURL:  strings.TrimSuffix(cfg.URL, "/"),
-		tokens:   cfg.Tokens,
-		priority: cfg.Priority,
-		markdown: cfg.Markdown,
-		renderer: cfg.Renderer,
-		logger:   cfg.Logger,
+		baseURL:     strings.TrimSuffix(cfg.URL, "/"),
+		tokens:      cfg.Tokens,
+		priority:    cfg.Priority,
+		markdown:    cfg.Markdown,
+		renderer:    cfg.Renderer,
+		routes:      cfg.Routes,
+		rcptTo:      rcptTo,
+		sent:        delivery.NewSentCache(delivery.SentTTL),
+		retries:     cfg.Retries,
+		retryMin:    cfg.RetryMin,
+		retryMax:    cfg.RetryMax,
+		logger:      cfg.Logger,
+		concurrency: concurrency,
+		limit:       cfg.Limit,
+		clientToken: cfg.ClientToken,
+		correlation: cfg.Correlation,
+		alerts:      newAlertStore(alertTTL),
+		provision:   newProvisioner(cfg.Provision),
 		http: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:   timeout,
+			Transport: cfg.Transport,
 		},
 	}
 }
 
 func (c *Client) Forward(ctx context.Context, msg *mail.Message) error {
-	title, body, err := c.renderer.Render(msg)
+	route := c.route(ctx, msg)
+
+	title, body, err := route.Renderer.Render(msg)
 	if err != nil {
-		return fmt.Errorf("render template: %w", err)
+		return &delivery.TemplateError{Err: err}
 	}
 
 	gotifyMsg := Message{
-		Title:    title,
-		Message:  body,
-		Priority: c.priority,
+		Priority: route.Priority,
+	}
+
+	key, resolved := c.correlate(msg)
+	if resolved {
+		if err := c.resolve(ctx, key); err != nil {
+			return err
+		}
+		if !c.correlation.ResolvedNote {
+			c.logger.Debug("alert resolved, not posting a note", "id", msg.ID, "key", key)
+			return nil
+		}
+		gotifyMsg.Priority = c.correlation.ResolvedPriority
 	}
 
 	if c.markdown {
@@ -74,45 +174,254 @@ func (c *Client) Forward(ctx context.Context, msg *mail.Message) error {
 		}
 	}
 
-	payload, err := json.Marshal(gotifyMsg)
-	if err != nil {
-		return fmt.Errorf("marshal message: %w", err)
+	parts := c.limit.apply(title, body)
+	payloads := make([][]byte, len(parts))
+	for i, p := range parts {
+		gotifyMsg.Title = p.title
+		gotifyMsg.Message = p.body
+		if payloads[i], err = json.Marshal(gotifyMsg); err != nil {
+			return fmt.Errorf("marshal message: %w", err)
+		}
+	}
+
+	// Send to all tokens of the route the parts that have not reached them yet
+	var jobs []job
+	for _, token := range route.Tokens {
+		dest := c.destination(token)
+		j := job{token: token}
+		for i := range parts {
+			if d := partDestination(dest, i); !slices.Contains(msg.Delivered, d) && !c.sent.Has(msg, d) {
+				j.parts = append(j.parts, i)
+			}
+		}
+		if len(j.parts) == 0 {
+			c.logger.Debug("skipping destination already delivered", "id", msg.ID, "destination", dest)
+			continue
+		}
+		jobs = append(jobs, j)
 	}
 
-	// Send to all configured tokens
+	results := c.fanOut(ctx, jobs, payloads)
+
 	var errs []error
-	for _, token := range c.tokens {
-		if err := c.send(ctx, token, payload); err != nil {
-			errs = append(errs, fmt.Errorf("token %s...: %w", token[:min(8, len(token))], err))
+	for i, j := range jobs {
+		prefix := j.token[:min(8, len(j.token))]
+		dest := c.destination(j.token)
+		var failed error
+		for _, r := range results[i] {
+			if r.err != nil {
+				failed = c.rejected(j.token, r.err)
+				break
+			}
+			d := partDestination(dest, r.part)
+			msg.Delivered = append(msg.Delivered, d)
+			c.sent.Add(msg, d)
+			if key != "" && !resolved && r.id > 0 {
+				c.track(ctx, key, d, r.id)
+			}
+		}
+		if failed != nil {
+			c.logger.Warn("gotify delivery failed", "id", msg.ID, "token", prefix+"...", "error", failed)
+			errs = append(errs, fmt.Errorf("token %s...: %w", prefix, failed))
+			continue
 		}
+		c.logger.Debug("gotify delivery succeeded", "id", msg.ID, "token", prefix+"...", "parts", len(results[i]))
 	}
 
 	if len(errs) > 0 {
-		return fmt.Errorf("failed to send to %d/%d tokens: %v", len(errs), len(c.tokens), errs)
+		return &delivery.Error{
+			Delivered: len(route.Tokens) - len(errs),
+			Total:     len(route.Tokens),
+			Err:       errors.Join(errs...),
+		}
 	}
 
 	return nil
 }
 
-func (c *Client) send(ctx context.Context, token string, payload []byte) error {
-	url := fmt.Sprintf("%s/message?token=%s", c.baseURL, token)
+// job is the parts of a message still to be sent for one token.
+type job struct {
+	token string
+	parts []int
+}
+
+// sendResult is the outcome of posting one part for a token.
+type sendResult struct {
+	part int
+	// id is the Gotify message ID, 0 if unknown.
+	id  int64
+	err error
+}
+
+// fanOut runs jobs with at most concurrency requests in flight and returns
+// the results of each job in order.
+func (c *Client) fanOut(ctx context.Context, jobs []job, payloads [][]byte) [][]sendResult {
+	results := make([][]sendResult, len(jobs))
+	sem := make(chan struct{}, c.concurrency)
+
+	var wg sync.WaitGroup
+	for i, j := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = c.sendParts(ctx, j, payloads)
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// sendParts sends the parts of a job in order and stops at the first
+// failure, so a split message never shows up with gaps.
+func (c *Client) sendParts(ctx context.Context, j job, payloads [][]byte) []sendResult {
+	var results []sendResult
+	for _, p := range j.parts {
+		id, err := c.send(ctx, j.token, payloads[p])
+		results = append(results, sendResult{part: p, id: id, err: err})
+		if err != nil {
+			break
+		}
+	}
+	return results
+}
+
+// destination returns a stable key for a token on this server that does not
+// reveal the token when persisted or logged.
+func (c *Client) destination(token string) string {
+	sum := sha256.Sum256([]byte(c.baseURL + "\n" + token))
+	return hex.EncodeToString(sum[:8])
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+// partDestination returns the key recording that part i of a split message
+// reached dest. The first part uses dest itself.
+func partDestination(dest string, i int) string {
+	if i == 0 {
+		return dest
+	}
+	return dest + "/" + strconv.Itoa(i+1)
+}
+
+func (c *Client) named(name string) (Route, bool) {
+	if r, ok := c.routes[name]; ok {
+		return r, true
+	}
+	r, ok := c.rcptTo[strings.ToLower(name)]
+	return r, ok
+}
+
+// route returns the settings of the route named by msg.Route, falling back
+// to the defaults of this server.
+func (c *Client) route(ctx context.Context, msg *mail.Message) Route {
+	if r, ok := c.named(msg.Route); ok && msg.Route != "" {
+		return r
+	}
+	route := Route{
+		Tokens:   c.tokens,
+		Priority: c.priority,
+		Renderer: c.renderer,
+	}
+	if c.provision != nil {
+		token, err := c.appToken(ctx, msg)
+		if err != nil {
+			c.logger.Warn("failed to provision gotify application, using default tokens", "id", msg.ID, "error", err)
+		} else if token != "" {
+			route.Tokens = []string{token}
+		}
+	}
+	return route
+}
+
+// send posts payload for token, retrying transient failures with jittered
+// exponential backoff. A Retry-After beyond RetryMax ends the retries early
+// so the caller can decide when to try again.
+func (c *Client) send(ctx context.Context, token string, payload []byte) (int64, error) {
+	for attempt := 0; ; attempt++ {
+		id, err := c.post(ctx, token, payload)
+		if err == nil || attempt >= c.retries || ctx.Err() != nil || delivery.Permanent(err) {
+			return id, err
+		}
+
+		wait := c.backoff(attempt)
+		var statusErr *delivery.StatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+			if statusErr.RetryAfter > c.retryMax {
+				return 0, err
+			}
+			wait = statusErr.RetryAfter
+		}
+
+		c.logger.Debug("retrying gotify request", "attempt", attempt+1, "wait", wait, "error", err)
+		select {
+		case <-ctx.Done():
+			return 0, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// backoff returns a random delay between half and all of RetryMin doubled
+// per attempt, capped at RetryMax.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.retryMin
+	for i := 0; i < attempt && d < c.retryMax; i++ {
+		d *= 2
+	}
+	d = min(d, c.retryMax)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// post sends payload for token and returns the ID Gotify assigned to the
+// message, or 0 if the response did not include one.
+func (c *Client) post(ctx context.Context, token string, payload []byte) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/message", bytes.NewReader(payload))
 	if err != nil {
-		return err
+		return 0, delivery.Redact(err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	// Keep the token out of URLs, which end up in proxy logs and errors
+	req.Header.Set("X-Gotify-Key", token)
 
 	resp, err := c.http.Do(req)
 	if err != nil {
-		return err
+		return 0, &delivery.RequestError{Err: delivery.Redact(err)}
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("unexpected status: %s", resp.Status)
+		return 0, delivery.NewStatusError(resp)
 	}
 
-	c.logger.Debug("message sent to gotify", "status", resp.Status)
+	var sent struct {
+		ID int64 `json:"id"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&sent)
+
+	c.logger.Debug("message sent to gotify", "status", resp.Status, "message_id", sent.ID)
+	return sent.ID, nil
+}
+
+// Probe checks that the Gotify server is reachable through its unauthenticated
+// health endpoint.
+func (c *Client) Probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/health", nil)
+	if err != nil {
+		return delivery.Redact(err)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return &delivery.RequestError{Err: delivery.Redact(err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return delivery.NewStatusError(resp)
+	}
 	return nil
 }
@@ -3,12 +3,18 @@ package gotify
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/alex/smtp-gotify/internal/delivery"
 	"github.com/alex/smtp-gotify/internal/mail"
 	"github.com/alex/smtp-gotify/internal/template"
 )
@@ -20,8 +26,11 @@ func TestClient_Forward(t *testing.T) {
 			t.Errorf("expected POST, got %s", r.Method)
 		}
 
-		if r.URL.Query().Get("token") != "test-token" {
-			t.Errorf("expected token test-token, got %s", r.URL.Query().Get("token"))
+		if r.Header.Get("X-Gotify-Key") != "test-token" {
+			t.Errorf("expected token test-token, got %s", r.Header.Get("X-Gotify-Key"))
+		}
+		if r.URL.RawQuery != "" {
+			t.Errorf("expected no query string, got %s", r.URL.RawQuery)
 		}
 
 		body, _ := io.ReadAll(r.Body)
@@ -99,9 +108,9 @@ func TestClient_ForwardWithMarkdown(t *testing.T) {
 }
 
 func TestClient_ForwardMultipleTokens(t *testing.T) {
-	callCount := 0
+	var callCount atomic.Int32
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		callCount++
+		callCount.Add(1)
 		w.WriteHeader(http.StatusOK)
 	}))
 	defer server.Close()
@@ -120,8 +129,93 @@ func TestClient_ForwardMultipleTokens(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if callCount != 3 {
-		t.Errorf("expected 3 calls, got %d", callCount)
+	if n := callCount.Load(); n != 3 {
+		t.Errorf("expected 3 calls, got %d", n)
+	}
+}
+
+func TestClient_ForwardPartialRetry(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Gotify-Key")
+		mu.Lock()
+		defer mu.Unlock()
+		calls[token]++
+		if token == "token2" && failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	client := NewClient(Config{
+		URL:      server.URL,
+		Tokens:   []string{"token1", "token2"},
+		Renderer: renderer,
+		Logger:   slog.Default(),
+	})
+
+	msg := &mail.Message{ID: "<1@example.com>", Subject: "Test", Body: "Body"}
+	err := client.Forward(context.Background(), msg)
+	var deliveryErr *delivery.Error
+	if !errors.As(err, &deliveryErr) || !deliveryErr.Partial() {
+		t.Fatalf("expected partial delivery error, got %v", err)
+	}
+	if len(msg.Delivered) != 1 {
+		t.Fatalf("expected 1 delivered destination, got %v", msg.Delivered)
+	}
+
+	// A sender retrying the same message only reaches the failed token
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	retry := &mail.Message{ID: "<1@example.com>", Subject: "Test", Body: "Body"}
+	if err := client.Forward(context.Background(), retry); err != nil {
+		t.Fatalf("unexpected error on retry: %v", err)
+	}
+	if calls["token1"] != 1 || calls["token2"] != 2 {
+		t.Errorf("expected token1 once and token2 twice, got %v", calls)
+	}
+}
+
+func TestClient_ForwardConcurrent(t *testing.T) {
+	// Each request waits until the other one arrives, which only succeeds
+	// when both tokens are posted to at the same time
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived.Done()
+		arrived.Wait()
+		if r.Header.Get("X-Gotify-Key") == "bad-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	client := NewClient(Config{
+		URL:         server.URL,
+		Tokens:      []string{"good-token", "bad-token"},
+		Renderer:    renderer,
+		Concurrency: 2,
+		Timeout:     2 * time.Second,
+		Logger:      slog.Default(),
+	})
+
+	msg := &mail.Message{Subject: "Test"}
+	err := client.Forward(context.Background(), msg)
+	var deliveryErr *delivery.Error
+	if !errors.As(err, &deliveryErr) || deliveryErr.Delivered != 1 || deliveryErr.Total != 2 {
+		t.Fatalf("expected 1/2 delivered, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "bad-toke...") || strings.Contains(err.Error(), "good-tok...") {
+		t.Errorf("expected error to name only the failed token, got %v", err)
 	}
 }
 
@@ -144,4 +238,250 @@ func TestClient_ForwardError(t *testing.T) {
 	if err == nil {
 		t.Error("expected error for server error response")
 	}
+
+	var statusErr *delivery.StatusError
+	if !errors.As(err, &statusErr) || !statusErr.Temporary() {
+		t.Errorf("expected temporary status error, got %v", err)
+	}
+}
+
+func TestClient_ForwardRetries(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	client := NewClient(Config{
+		URL:      server.URL,
+		Tokens:   []string{"test-token"},
+		Renderer: renderer,
+		Retries:  2,
+		RetryMin: time.Millisecond,
+		RetryMax: 5 * time.Millisecond,
+		Logger:   slog.Default(),
+	})
+
+	if err := client.Forward(context.Background(), &mail.Message{Subject: "Test"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", calls)
+	}
+}
+
+func TestClient_ForwardPermanentNoRetry(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	client := NewClient(Config{
+		URL:      server.URL,
+		Tokens:   []string{"bad-token"},
+		Renderer: renderer,
+		Retries:  3,
+		RetryMin: time.Millisecond,
+		RetryMax: time.Millisecond,
+		Logger:   slog.Default(),
+	})
+
+	err := client.Forward(context.Background(), &mail.Message{Subject: "Test"})
+	if !delivery.Permanent(err) {
+		t.Errorf("expected permanent error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected a single attempt, got %d", calls)
+	}
+}
+
+func TestClient_ForwardUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	client := NewClient(Config{
+		URL:      server.URL,
+		Tokens:   []string{"test-token"},
+		Renderer: renderer,
+		Logger:   slog.Default(),
+	})
+
+	err := client.Forward(context.Background(), &mail.Message{Subject: "Test"})
+	if err == nil || delivery.Permanent(err) {
+		t.Errorf("expected temporary error for refused connection, got %v", err)
+	}
+}
+
+func TestClient_ErrorRedactsCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	client := NewClient(Config{
+		URL:      strings.Replace(server.URL, "http://", "http://admin:hunter2@", 1),
+		Tokens:   []string{"secret-token-value"},
+		Renderer: renderer,
+		Logger:   slog.Default(),
+	})
+
+	err := client.Forward(context.Background(), &mail.Message{Subject: "Test"})
+	if err == nil {
+		t.Fatal("expected error for unreachable server")
+	}
+	if strings.Contains(err.Error(), "hunter2") || strings.Contains(err.Error(), "secret-token-value") {
+		t.Errorf("expected credentials to be redacted, got %v", err)
+	}
+}
+
+func TestClient_ForwardUserRoute(t *testing.T) {
+	var token string
+	var received Message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get("X-Gotify-Key")
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &received)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	printerRenderer, _ := template.NewRenderer("[{{.User}}] {{.Subject}}", "{{.Body}}")
+	client := NewClient(Config{
+		URL:      server.URL,
+		Tokens:   []string{"default-token"},
+		Priority: 5,
+		Renderer: renderer,
+		Routes: map[string]Route{
+			"printer": {Tokens: []string{"printer-token"}, Priority: 2, Renderer: printerRenderer},
+		},
+		Logger: slog.Default(),
+	})
+
+	msg := &mail.Message{Subject: "Paper jam", Body: "Tray 2", User: "printer", Route: "printer"}
+	if err := client.Forward(context.Background(), msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if token != "printer-token" {
+		t.Errorf("expected printer-token, got %s", token)
+	}
+	if received.Priority != 2 {
+		t.Errorf("expected priority 2, got %d", received.Priority)
+	}
+	if received.Title != "[printer] Paper jam" {
+		t.Errorf("expected user title, got %s", received.Title)
+	}
+
+	msg = &mail.Message{Subject: "Other", Body: "Body", User: "unknown", Route: "unknown"}
+	if err := client.Forward(context.Background(), msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if token != "default-token" {
+		t.Errorf("expected default-token for unrouted user, got %s", token)
+	}
+}
+
+func TestClient_ForwardRecipientRoute(t *testing.T) {
+	var token string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get("X-Gotify-Key")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	client := NewClient(Config{
+		URL:      server.URL,
+		Tokens:   []string{"default-token"},
+		Renderer: renderer,
+		RecipientRoutes: map[string]Route{
+			"Backup":          {Tokens: []string{"backup-token"}, Renderer: renderer},
+			"ups@example.com": {Tokens: []string{"ups-token"}, Renderer: renderer},
+		},
+		Logger: slog.Default(),
+	})
+
+	tests := []struct {
+		route string
+		want  string
+	}{
+		{"backup", "backup-token"},
+		{"UPS@example.com", "ups-token"},
+		{"", "default-token"},
+	}
+
+	for _, tt := range tests {
+		msg := &mail.Message{Subject: "Test", Route: tt.route}
+		if err := client.Forward(context.Background(), msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if token != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.route, tt.want, token)
+		}
+	}
+}
+
+func TestClient_ForwardTemplateError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("unexpected request for a message that cannot be rendered")
+	}))
+	defer server.Close()
+
+	renderer, _ := template.NewRenderer(`{{match "(" .Subject}}`, "{{.Body}}")
+	client := NewClient(Config{
+		URL:      server.URL,
+		Tokens:   []string{"token"},
+		Renderer: renderer,
+		Logger:   slog.Default(),
+	})
+
+	err := client.Forward(context.Background(), &mail.Message{Subject: "Test"})
+	if err == nil || !delivery.Permanent(err) {
+		t.Errorf("expected permanent template error, got %v", err)
+	}
+}
+
+func TestClient_ForwardReusedMessageID(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	client := NewClient(Config{
+		URL:      server.URL,
+		Tokens:   []string{"token"},
+		Renderer: renderer,
+		Logger:   slog.Default(),
+	})
+
+	for _, msg := range []*mail.Message{
+		{ID: "<1@example.com>", Hash: "first", Subject: "Disk full"},
+		{ID: "<1@example.com>", Hash: "second", Subject: "Disk OK"},
+		{ID: "<1@example.com>", Hash: "second", Subject: "Disk OK"},
+	} {
+		if err := client.Forward(context.Background(), msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("expected a different message with the same Message-ID to be sent, got %d requests", calls)
+	}
 }
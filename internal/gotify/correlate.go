@@ -0,0 +1,202 @@
+package gotify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alex/smtp-gotify/internal/delivery"
+	"github.com/alex/smtp-gotify/internal/mail"
+	"github.com/alex/smtp-gotify/internal/template"
+)
+
+// Correlation links the notifications of a recurring alert, so a "resolved"
+// mail removes the earlier "firing" notification instead of piling up next
+// to it.
+type Correlation struct {
+	// Key extracts the alert from a message. Messages with an empty key are
+	// delivered as usual.
+	Key *template.Key
+	// Resolved matches the subject of mails that end an alert.
+	Resolved *regexp.Regexp
+	// ResolvedNote, if set, posts the resolved mail at ResolvedPriority
+	// after deleting the earlier notification.
+	ResolvedNote     bool
+	ResolvedPriority int
+	// Supersede, if set, deletes the earlier notification when the same
+	// alert fires again, so a repeated alert shows up only once.
+	Supersede bool
+	// TTL is how long a notification is remembered, 0 means a week.
+	TTL time.Duration
+}
+
+// ResolveError is returned when the notifications of a resolved alert could
+// not all be deleted. The remaining ones are kept, so the sender's retry of
+// the resolved mail deletes them; it is always temporary.
+type ResolveError struct {
+	Err error
+}
+
+func (e *ResolveError) Error() string {
+	return "delete resolved notifications: " + e.Err.Error()
+}
+
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ResolveError) Temporary() bool {
+	return true
+}
+
+// correlate returns the correlation key of msg and whether it resolves the
+// alert.
+func (c *Client) correlate(msg *mail.Message) (key string, resolved bool) {
+	if c.correlation.Key == nil {
+		return "", false
+	}
+	key, err := c.correlation.Key.Render(msg)
+	if err != nil {
+		c.logger.Warn("failed to render correlation key", "id", msg.ID, "error", err)
+		return "", false
+	}
+	return key, key != "" && c.correlation.Resolved != nil && c.correlation.Resolved.MatchString(msg.Subject)
+}
+
+// resolve deletes the notifications recorded for key. Each one is forgotten
+// once it is deleted, so a failed attempt can be retried.
+func (c *Client) resolve(ctx context.Context, key string) error {
+	var errs []error
+	for dest, ids := range c.alerts.get(key) {
+		for _, id := range ids {
+			if err := c.deleteMessage(ctx, id); err != nil {
+				c.logger.Warn("failed to delete resolved notification", "key", key, "destination", dest, "message_id", id, "error", err)
+				errs = append(errs, fmt.Errorf("message %d: %w", id, err))
+				continue
+			}
+			c.alerts.remove(key, dest, id)
+			c.logger.Debug("deleted resolved notification", "key", key, "destination", dest, "message_id", id)
+		}
+	}
+	if len(errs) > 0 {
+		return &ResolveError{Err: errors.Join(errs...)}
+	}
+	return nil
+}
+
+// track records the notification sent for key to dest. With Supersede set,
+// the earlier notifications of the alert at dest are deleted.
+func (c *Client) track(ctx context.Context, key, dest string, id int64) {
+	prev := c.alerts.add(key, dest, id)
+	if !c.correlation.Supersede {
+		return
+	}
+	for _, p := range prev {
+		if err := c.deleteMessage(ctx, p); err != nil {
+			// Kept, so the resolved mail tries again
+			c.logger.Warn("failed to delete superseded notification", "key", key, "destination", dest, "message_id", p, "error", err)
+			continue
+		}
+		c.alerts.remove(key, dest, p)
+	}
+}
+
+func (c *Client) deleteMessage(ctx context.Context, id int64) error {
+	err := c.do(ctx, http.MethodDelete, "/message/"+strconv.FormatInt(id, 10), "", nil, nil)
+	// Already dismissed by the user
+	var statusErr *delivery.StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// alertStore maps correlation keys to the Gotify message IDs posted for each
+// destination. It lives in memory, so alerts firing before a restart are
+// not deleted when they resolve.
+type alertStore struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	entries   map[string]*alertEntry
+	lastSweep time.Time
+}
+
+type alertEntry struct {
+	ids     map[string][]int64
+	expires time.Time
+}
+
+func newAlertStore(ttl time.Duration) *alertStore {
+	return &alertStore{
+		ttl:     ttl,
+		entries: make(map[string]*alertEntry),
+	}
+}
+
+// add records id and returns the message IDs recorded before it.
+func (s *alertStore) add(key, dest string, id int64) []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > s.ttl {
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	e, ok := s.entries[key]
+	if !ok || now.After(e.expires) {
+		e = &alertEntry{ids: make(map[string][]int64)}
+		s.entries[key] = e
+	}
+	prev := slices.Clone(e.ids[dest])
+	if !slices.Contains(e.ids[dest], id) {
+		e.ids[dest] = append(e.ids[dest], id)
+	}
+	e.expires = now.Add(s.ttl)
+	return slices.DeleteFunc(prev, func(p int64) bool { return p == id })
+}
+
+// get returns a copy of the message IDs recorded for key.
+func (s *alertStore) get(key string) map[string][]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil
+	}
+	ids := make(map[string][]int64, len(e.ids))
+	for dest, list := range e.ids {
+		ids[dest] = slices.Clone(list)
+	}
+	return ids
+}
+
+// remove forgets id, and key once it has no IDs left.
+func (s *alertStore) remove(key, dest string, id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	e.ids[dest] = slices.DeleteFunc(e.ids[dest], func(p int64) bool { return p == id })
+	if len(e.ids[dest]) == 0 {
+		delete(e.ids, dest)
+	}
+	if len(e.ids) == 0 {
+		delete(s.entries, key)
+	}
+}
@@ -0,0 +1,216 @@
+package gotify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/alex/smtp-gotify/internal/delivery"
+	"github.com/alex/smtp-gotify/internal/mail"
+	"github.com/alex/smtp-gotify/internal/template"
+)
+
+type fakeGotify struct {
+	mu       sync.Mutex
+	nextID   int64
+	posted   []Message
+	deleted  []string
+	wrongKey int
+	notFound bool
+	// failDeletes makes the next deletes fail with 500
+	failDeletes int
+}
+
+func (f *fakeGotify) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Apps post messages, deleting them takes a client token
+	want := map[string]string{http.MethodPost: "app-token", http.MethodDelete: "client-token"}
+	if r.Header.Get("X-Gotify-Key") != want[r.Method] {
+		f.wrongKey++
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var m Message
+		_ = json.NewDecoder(r.Body).Decode(&m)
+		f.posted = append(f.posted, m)
+		f.nextID++
+		_ = json.NewEncoder(w).Encode(map[string]int64{"id": f.nextID})
+	case http.MethodDelete:
+		if f.failDeletes > 0 {
+			f.failDeletes--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		f.deleted = append(f.deleted, r.URL.Path)
+		if f.notFound {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+func newCorrelatedClient(t *testing.T, url string, note, supersede bool) *Client {
+	t.Helper()
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	key, err := template.NewKey(`{{match "\\] (.+)$" .Subject}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewClient(Config{
+		URL:         url,
+		Tokens:      []string{"app-token"},
+		Priority:    8,
+		Renderer:    renderer,
+		ClientToken: "client-token",
+		Correlation: Correlation{
+			Key:              key,
+			Resolved:         regexp.MustCompile(`(?i)\bresolved\b`),
+			ResolvedNote:     note,
+			ResolvedPriority: 1,
+			Supersede:        supersede,
+		},
+		Logger: slog.Default(),
+	})
+}
+
+func TestClient_ForwardResolvedDeletesNotification(t *testing.T) {
+	fake := &fakeGotify{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := newCorrelatedClient(t, server.URL, true, true)
+	ctx := context.Background()
+
+	for i, subject := range []string{"[FIRING:1] HighCPU", "[FIRING:1] HighCPU", "[RESOLVED] HighCPU"} {
+		msg := &mail.Message{ID: fmt.Sprint(i), Subject: subject}
+		if err := client.Forward(ctx, msg); err != nil {
+			t.Fatalf("forward %q: %v", subject, err)
+		}
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	// The repeated alert replaces the first, the resolved mail the second
+	if want := []string{"/message/1", "/message/2"}; !slices.Equal(fake.deleted, want) {
+		t.Errorf("expected deletes %v, got %v", want, fake.deleted)
+	}
+	if len(fake.posted) != 3 {
+		t.Fatalf("expected 3 posts, got %d", len(fake.posted))
+	}
+	if fake.posted[0].Priority != 8 || fake.posted[2].Priority != 1 {
+		t.Errorf("expected priorities 8 and 1, got %d and %d", fake.posted[0].Priority, fake.posted[2].Priority)
+	}
+	if fake.wrongKey > 0 {
+		t.Errorf("expected posts with the app token and deletes with the client token, %d were not", fake.wrongKey)
+	}
+}
+
+func TestClient_ForwardResolvedWithoutNote(t *testing.T) {
+	fake := &fakeGotify{notFound: true}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := newCorrelatedClient(t, server.URL, false, false)
+	ctx := context.Background()
+
+	if err := client.Forward(ctx, &mail.Message{ID: "1", Subject: "[FIRING:1] DiskFull"}); err != nil {
+		t.Fatal(err)
+	}
+	// Already dismissed in the app, which is not an error
+	if err := client.Forward(ctx, &mail.Message{ID: "2", Subject: "[RESOLVED] DiskFull"}); err != nil {
+		t.Fatal(err)
+	}
+	// Unrelated mails are not correlated
+	if err := client.Forward(ctx, &mail.Message{ID: "3", Subject: "Backup done"}); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if len(fake.posted) != 2 {
+		t.Errorf("expected the resolved mail not to be posted, got %d posts", len(fake.posted))
+	}
+	if len(fake.deleted) != 1 {
+		t.Errorf("expected 1 delete, got %v", fake.deleted)
+	}
+}
+
+func TestClient_ForwardRepeatedKeepsNotifications(t *testing.T) {
+	fake := &fakeGotify{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := newCorrelatedClient(t, server.URL, false, false)
+	ctx := context.Background()
+
+	for i, subject := range []string{"[FIRING:1] HighCPU", "[FIRING:1] HighCPU"} {
+		if err := client.Forward(ctx, &mail.Message{ID: fmt.Sprint(i), Subject: subject}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.mu.Lock()
+	if len(fake.deleted) != 0 {
+		t.Errorf("expected repeated alerts to be kept without supersede, got deletes %v", fake.deleted)
+	}
+	fake.mu.Unlock()
+
+	if err := client.Forward(ctx, &mail.Message{ID: "2", Subject: "[RESOLVED] HighCPU"}); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if want := []string{"/message/1", "/message/2"}; !slices.Equal(fake.deleted, want) {
+		t.Errorf("expected deletes %v, got %v", want, fake.deleted)
+	}
+}
+
+func TestClient_ForwardResolvedRetriesFailedDelete(t *testing.T) {
+	fake := &fakeGotify{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := newCorrelatedClient(t, server.URL, true, false)
+	ctx := context.Background()
+
+	if err := client.Forward(ctx, &mail.Message{ID: "1", Subject: "[FIRING:1] DiskFull"}); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.mu.Lock()
+	fake.failDeletes = 1
+	fake.mu.Unlock()
+
+	resolved := &mail.Message{ID: "2", Subject: "[RESOLVED] DiskFull"}
+	err := client.Forward(ctx, resolved)
+	var resolveErr *ResolveError
+	if !errors.As(err, &resolveErr) || delivery.Permanent(err) {
+		t.Fatalf("expected temporary resolve error, got %v", err)
+	}
+
+	// The sender retries and the notification is still known
+	if err := client.Forward(ctx, resolved); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if want := []string{"/message/1"}; !slices.Equal(fake.deleted, want) {
+		t.Errorf("expected deletes %v, got %v", want, fake.deleted)
+	}
+	if len(fake.posted) != 2 {
+		t.Errorf("expected the resolved note to be posted once, got %d posts", len(fake.posted))
+	}
+}
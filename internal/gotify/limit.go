@@ -0,0 +1,117 @@
+package gotify
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Strategies for bodies over Limit.MaxSize.
+const (
+	// Truncate keeps the start of the body.
+	Truncate = "truncate"
+	// HeadTail keeps the start and the end, where log dumps usually end
+	// with the error.
+	HeadTail = "headtail"
+	// Split sends the body as a numbered series of notifications.
+	Split = "split"
+)
+
+// Limit bounds the size of a notification body. Bodies are cut on UTF-8
+// boundaries and marked with the number of bytes left out.
+type Limit struct {
+	// MaxSize is the body size in bytes, 0 means unlimited.
+	MaxSize int
+	// Strategy is Truncate, HeadTail or Split, Truncate if empty.
+	Strategy string
+	// MaxParts caps a split series, 0 means 10. The last part is truncated.
+	MaxParts int
+}
+
+// part is one notification of a possibly split message.
+type part struct {
+	title string
+	body  string
+}
+
+func (l Limit) apply(title, body string) []part {
+	if l.MaxSize <= 0 || len(body) <= l.MaxSize {
+		return []part{{title: title, body: body}}
+	}
+	switch l.Strategy {
+	case HeadTail:
+		return []part{{title: title, body: headTail(body, l.MaxSize)}}
+	case Split:
+		maxParts := l.MaxParts
+		if maxParts <= 0 {
+			maxParts = 10
+		}
+		return split(title, body, l.MaxSize, maxParts)
+	default:
+		return []part{{title: title, body: truncate(body, l.MaxSize)}}
+	}
+}
+
+func truncate(body string, max int) string {
+	keep := cutPoint(body, max-len(omitted(len(body))))
+	return body[:keep] + omitted(len(body)-keep)
+}
+
+func headTail(body string, max int) string {
+	keep := max - len(omittedBetween(len(body)))
+	head := cutPoint(body, keep/2)
+	tail := len(body) - (keep - head)
+	for tail < len(body) && !utf8.RuneStart(body[tail]) {
+		tail++
+	}
+	return body[:head] + omittedBetween(tail-head) + body[tail:]
+}
+
+// split cuts body into at most maxParts chunks, preferring line breaks, and
+// numbers the titles.
+func split(title, body string, max, maxParts int) []part {
+	var chunks []string
+	for len(body) > max && len(chunks) < maxParts-1 {
+		n := cutPoint(body, max)
+		if i := strings.LastIndexByte(body[:n], '\n'); i >= n/2 {
+			n = i + 1
+		}
+		chunks = append(chunks, body[:n])
+		body = body[n:]
+	}
+	if len(body) > max {
+		body = truncate(body, max)
+	}
+	chunks = append(chunks, body)
+
+	parts := make([]part, len(chunks))
+	for i, chunk := range chunks {
+		parts[i] = part{
+			title: fmt.Sprintf("%s (%d/%d)", title, i+1, len(chunks)),
+			body:  chunk,
+		}
+	}
+	return parts
+}
+
+// cutPoint returns the largest index not above n that starts a rune.
+func cutPoint(s string, n int) int {
+	if n <= 0 {
+		return 0
+	}
+	if n >= len(s) {
+		return len(s)
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return n
+}
+
+func omitted(n int) string {
+	return fmt.Sprintf("\n… [%d bytes omitted]", n)
+}
+
+func omittedBetween(n int) string {
+	return fmt.Sprintf("\n… [%d bytes omitted] …\n", n)
+}
@@ -0,0 +1,129 @@
+package gotify
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/alex/smtp-gotify/internal/mail"
+	"github.com/alex/smtp-gotify/internal/template"
+)
+
+func TestLimit_Apply(t *testing.T) {
+	body := strings.Repeat("é", 300) // 600 bytes
+
+	tests := []struct {
+		name     string
+		limit    Limit
+		parts    int
+		contains string
+	}{
+		{"unlimited", Limit{}, 1, ""},
+		{"fits", Limit{MaxSize: 1000}, 1, ""},
+		{"truncate", Limit{MaxSize: 200}, 1, "bytes omitted]"},
+		{"headtail", Limit{MaxSize: 200, Strategy: HeadTail}, 1, "bytes omitted] …\n"},
+		{"split", Limit{MaxSize: 200, Strategy: Split}, 3, ""},
+		{"split capped", Limit{MaxSize: 200, Strategy: Split, MaxParts: 2}, 2, "bytes omitted]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := tt.limit.apply("Log", body)
+			if len(parts) != tt.parts {
+				t.Fatalf("expected %d parts, got %d", tt.parts, len(parts))
+			}
+			for _, p := range parts {
+				if tt.limit.MaxSize > 0 && len(p.body) > tt.limit.MaxSize {
+					t.Errorf("part of %d bytes exceeds %d", len(p.body), tt.limit.MaxSize)
+				}
+				if !utf8.ValidString(p.body) {
+					t.Errorf("part is not valid UTF-8: %q", p.body)
+				}
+			}
+			if tt.contains != "" && !strings.Contains(parts[len(parts)-1].body, tt.contains) {
+				t.Errorf("expected %q in %q", tt.contains, parts[len(parts)-1].body)
+			}
+		})
+	}
+}
+
+func TestLimit_ApplyKeepsEnds(t *testing.T) {
+	body := "start " + strings.Repeat("x", 1000) + " end"
+
+	truncated := Limit{MaxSize: 100}.apply("Log", body)[0].body
+	if !strings.HasPrefix(truncated, "start ") || !strings.HasSuffix(truncated, " bytes omitted]") || len(truncated) > 100 {
+		t.Errorf("unexpected truncated body %q", truncated)
+	}
+
+	headTail := Limit{MaxSize: 100, Strategy: HeadTail}.apply("Log", body)[0].body
+	if !strings.HasPrefix(headTail, "start ") || !strings.HasSuffix(headTail, " end") {
+		t.Errorf("unexpected head and tail body %q", headTail)
+	}
+}
+
+func TestLimit_SplitPrefersLines(t *testing.T) {
+	body := strings.Repeat("line of log output\n", 20) // 380 bytes
+
+	parts := Limit{MaxSize: 200, Strategy: Split}.apply("Log", body)
+	if len(parts) != 2 {
+		t.Fatalf("expected 2 parts, got %d", len(parts))
+	}
+	if !strings.HasSuffix(parts[0].body, "\n") {
+		t.Errorf("expected first part to end at a line break, got %q", parts[0].body)
+	}
+	if parts[0].title != "Log (1/2)" || parts[1].title != "Log (2/2)" {
+		t.Errorf("unexpected titles %q and %q", parts[0].title, parts[1].title)
+	}
+	if parts[0].body+parts[1].body != body {
+		t.Error("expected parts to add up to the body")
+	}
+}
+
+func TestClient_ForwardSplitRetry(t *testing.T) {
+	var mu sync.Mutex
+	var titles []string
+	failSecond := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var m Message
+		_ = json.NewDecoder(r.Body).Decode(&m)
+		if failSecond && strings.HasSuffix(m.Title, "(2/3)") {
+			failSecond = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		titles = append(titles, m.Title)
+	}))
+	defer server.Close()
+
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	client := NewClient(Config{
+		URL:      server.URL,
+		Tokens:   []string{"test-token"},
+		Renderer: renderer,
+		Limit:    Limit{MaxSize: 100, Strategy: Split},
+		Logger:   slog.Default(),
+	})
+
+	msg := &mail.Message{ID: "<log@example.com>", Subject: "Log", Body: strings.Repeat("x", 250)}
+	if err := client.Forward(context.Background(), msg); err == nil {
+		t.Fatal("expected error when a part fails")
+	}
+	// The retry continues with the part that failed
+	if err := client.Forward(context.Background(), msg); err != nil {
+		t.Fatalf("unexpected error on retry: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"Log (1/3)", "Log (2/3)", "Log (3/3)"}
+	if strings.Join(titles, ",") != strings.Join(want, ",") {
+		t.Errorf("expected parts %v in order, got %v", want, titles)
+	}
+}
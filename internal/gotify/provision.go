@@ -0,0 +1,294 @@
+package gotify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	netmail "net/mail"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alex/smtp-gotify/internal/delivery"
+	"github.com/alex/smtp-gotify/internal/mail"
+)
+
+// Keys an application can be provisioned for.
+const (
+	BySenderDomain = "sender-domain"
+	ByUser         = "user"
+	ByRecipient    = "recipient"
+)
+
+// Provision creates one Gotify application per sending system, so each
+// shows up separately in the Gotify clients without setting up tokens by
+// hand. Applications are found again by name after a restart.
+//
+// Sender domains and recipients are chosen by whoever sends the mail, so
+// with those keys Allow or MaxApps keep a client from creating applications
+// at will. Only ByUser is a key the client cannot make up.
+type Provision struct {
+	// By is BySenderDomain, ByUser or ByRecipient.
+	By string
+	// Allow, if set, lists the keys applications are provisioned for.
+	// Messages with other keys go to the default tokens.
+	Allow []string
+	// MaxApps bounds the number of provisioned applications, 0 means no
+	// limit. They are counted in Gotify by name prefix and description, so
+	// the limit holds across restarts.
+	MaxApps int
+	// NamePrefix is prepended to the key to form the application name.
+	NamePrefix  string
+	Description string
+	// Icon, if set, is uploaded as the image of new applications. Gotify
+	// tells the format from the extension of IconName.
+	Icon     []byte
+	IconName string
+}
+
+type application struct {
+	ID          int64  `json:"id"`
+	Token       string `json:"token"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// errProvisionLimit is returned when MaxApps applications exist already.
+var errProvisionLimit = errors.New("application limit reached")
+
+// AppTokenError is returned when Gotify rejects the token of a provisioned
+// application, as it does once the application is deleted. The token is
+// forgotten, so the retry finds or re-creates the application; it is
+// always temporary.
+type AppTokenError struct {
+	Err error
+}
+
+func (e *AppTokenError) Error() string {
+	return "application token rejected: " + e.Err.Error()
+}
+
+func (e *AppTokenError) Unwrap() error {
+	return e.Err
+}
+
+func (e *AppTokenError) Temporary() bool {
+	return true
+}
+
+// provisioner caches application tokens by name. create serializes lookups
+// that miss the cache, so concurrent messages from a new system do not
+// create the application twice.
+type provisioner struct {
+	cfg    Provision
+	allow  map[string]bool
+	create sync.Mutex
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+func newProvisioner(cfg Provision) *provisioner {
+	if cfg.By == "" {
+		return nil
+	}
+	p := &provisioner{
+		cfg:    cfg,
+		tokens: make(map[string]string),
+	}
+	if len(cfg.Allow) > 0 {
+		p.allow = make(map[string]bool, len(cfg.Allow))
+		for _, key := range cfg.Allow {
+			if cfg.By != ByUser {
+				key = strings.ToLower(key)
+			}
+			p.allow[key] = true
+		}
+	}
+	return p
+}
+
+func (p *provisioner) cached(name string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	token, ok := p.tokens[name]
+	return token, ok
+}
+
+func (p *provisioner) store(name, token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tokens[name] = token
+}
+
+// forget drops the applications with token and reports whether there were
+// any.
+func (p *provisioner) forget(token string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	found := false
+	for name, t := range p.tokens {
+		if t == token {
+			delete(p.tokens, name)
+			found = true
+		}
+	}
+	return found
+}
+
+// rejected checks whether err means the provisioned application behind
+// token is gone, forgetting it if so.
+func (c *Client) rejected(token string, err error) error {
+	var statusErr *delivery.StatusError
+	if c.provision == nil || !errors.As(err, &statusErr) {
+		return err
+	}
+	if statusErr.StatusCode != http.StatusUnauthorized && statusErr.StatusCode != http.StatusForbidden {
+		return err
+	}
+	if !c.provision.forget(token) {
+		return err
+	}
+	c.logger.Warn("provisioned gotify application rejected its token, looking it up again on retry", "error", err)
+	return &AppTokenError{Err: err}
+}
+
+// key returns the sending system msg belongs to, or "" if unknown or not
+// allowed.
+func (p *provisioner) key(msg *mail.Message) string {
+	key := p.rawKey(msg)
+	if p.allow != nil && !p.allow[key] {
+		return ""
+	}
+	return key
+}
+
+func (p *provisioner) rawKey(msg *mail.Message) string {
+	switch p.cfg.By {
+	case BySenderDomain:
+		from := msg.From
+		if addr, err := netmail.ParseAddress(from); err == nil {
+			from = addr.Address
+		}
+		if i := strings.LastIndexByte(from, '@'); i >= 0 {
+			return strings.ToLower(strings.Trim(from[i+1:], "<> "))
+		}
+	case ByUser:
+		return msg.User
+	case ByRecipient:
+		if len(msg.Recipients) > 0 {
+			local, _, _ := strings.Cut(strings.Trim(msg.Recipients[0], "<>"), "@")
+			return strings.ToLower(local)
+		}
+	}
+	return ""
+}
+
+// appToken returns the token of the application for msg, creating it on
+// first use, or "" if msg has no key.
+func (c *Client) appToken(ctx context.Context, msg *mail.Message) (string, error) {
+	p := c.provision
+	key := p.key(msg)
+	if key == "" {
+		return "", nil
+	}
+	name := p.cfg.NamePrefix + key
+	if token, ok := p.cached(name); ok {
+		return token, nil
+	}
+
+	p.create.Lock()
+	defer p.create.Unlock()
+	if token, ok := p.cached(name); ok {
+		return token, nil
+	}
+
+	// Reuse applications created before a restart or by hand
+	var apps []application
+	if err := c.do(ctx, http.MethodGet, "/application", "", nil, &apps); err != nil {
+		return "", fmt.Errorf("list applications: %w", err)
+	}
+	provisioned := 0
+	for _, app := range apps {
+		p.store(app.Name, app.Token)
+		if strings.HasPrefix(app.Name, p.cfg.NamePrefix) && app.Description == p.cfg.Description {
+			provisioned++
+		}
+	}
+	if token, ok := p.cached(name); ok {
+		return token, nil
+	}
+	if p.cfg.MaxApps > 0 && provisioned >= p.cfg.MaxApps {
+		return "", fmt.Errorf("create application %s: %w", name, errProvisionLimit)
+	}
+
+	body, err := json.Marshal(map[string]string{"name": name, "description": p.cfg.Description})
+	if err != nil {
+		return "", fmt.Errorf("marshal application: %w", err)
+	}
+	var app application
+	if err := c.do(ctx, http.MethodPost, "/application", "application/json", bytes.NewReader(body), &app); err != nil {
+		return "", fmt.Errorf("create application: %w", err)
+	}
+	c.logger.Info("created gotify application", "name", name, "app_id", app.ID)
+
+	if len(p.cfg.Icon) > 0 {
+		if err := c.uploadIcon(ctx, app.ID); err != nil {
+			c.logger.Warn("failed to upload application icon", "name", name, "app_id", app.ID, "error", err)
+		}
+	}
+
+	p.store(name, app.Token)
+	return app.Token, nil
+}
+
+func (c *Client) uploadIcon(ctx context.Context, id int64) error {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	part, err := w.CreateFormFile("file", c.provision.cfg.IconName)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(c.provision.cfg.Icon); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPost, "/application/"+strconv.FormatInt(id, 10)+"/image", w.FormDataContentType(), &buf, nil)
+}
+
+// do sends a request authenticated with the client token and decodes the
+// response into out, if set.
+func (c *Client) do(ctx context.Context, method, path, contentType string, body io.Reader, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return delivery.Redact(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("X-Gotify-Key", c.clientToken)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return &delivery.RequestError{Err: delivery.Redact(err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return delivery.NewStatusError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package gotify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/alex/smtp-gotify/internal/delivery"
+	"github.com/alex/smtp-gotify/internal/mail"
+	"github.com/alex/smtp-gotify/internal/template"
+)
+
+type fakeApps struct {
+	mu      sync.Mutex
+	apps    []application
+	created int
+	icons   map[string]string
+	sentTo  []string
+	// revoked tokens are rejected with 401
+	revoked map[string]bool
+}
+
+func (f *fakeApps) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := r.Header.Get("X-Gotify-Key")
+	switch {
+	case r.URL.Path == "/message":
+		if f.revoked[key] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.sentTo = append(f.sentTo, key)
+		return
+	case key != "client-token":
+		w.WriteHeader(http.StatusUnauthorized)
+	case r.Method == http.MethodGet && r.URL.Path == "/application":
+		_ = json.NewEncoder(w).Encode(f.apps)
+	case r.Method == http.MethodPost && r.URL.Path == "/application":
+		var req map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		f.created++
+		app := application{ID: int64(len(f.apps) + 1), Token: "app-" + req["name"], Name: req["name"], Description: req["description"]}
+		f.apps = append(f.apps, app)
+		_ = json.NewEncoder(w).Encode(app)
+	case r.Method == http.MethodPost:
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		file.Close()
+		f.icons[r.URL.Path] = header.Filename
+	}
+}
+
+func TestClient_ForwardProvision(t *testing.T) {
+	fake := &fakeApps{
+		apps:  []application{{ID: 1, Token: "app-existing", Name: "mail: nas.lan"}},
+		icons: map[string]string{},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	client := NewClient(Config{
+		URL:         server.URL,
+		Tokens:      []string{"default-token"},
+		Renderer:    renderer,
+		ClientToken: "client-token",
+		Provision: Provision{
+			By:         BySenderDomain,
+			NamePrefix: "mail: ",
+			Icon:       []byte("png"),
+			IconName:   "icon.png",
+		},
+		Logger: slog.Default(),
+	})
+
+	from := []string{"Backup <backup@NAS.lan>", "ups@printer.lan", "ups@printer.lan", "unknown"}
+	var wg sync.WaitGroup
+	for i, f := range from {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msg := &mail.Message{ID: fmt.Sprint(i), From: f}
+			if err := client.Forward(context.Background(), msg); err != nil {
+				t.Errorf("forward from %s: %v", f, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if fake.created != 1 {
+		t.Errorf("expected 1 application to be created, got %d", fake.created)
+	}
+	if fake.icons["/application/2/image"] != "icon.png" {
+		t.Errorf("expected icon upload for the new application, got %v", fake.icons)
+	}
+	counts := map[string]int{}
+	for _, key := range fake.sentTo {
+		counts[key]++
+	}
+	want := map[string]int{"app-existing": 1, "app-mail: printer.lan": 2, "default-token": 1}
+	if fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Errorf("expected messages per token %v, got %v", want, counts)
+	}
+}
+
+func TestClient_ForwardProvisionFailure(t *testing.T) {
+	var sentTo string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/message" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		sentTo = r.Header.Get("X-Gotify-Key")
+	}))
+	defer server.Close()
+
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	client := NewClient(Config{
+		URL:         server.URL,
+		Tokens:      []string{"default-token"},
+		Renderer:    renderer,
+		ClientToken: "revoked",
+		Provision:   Provision{By: ByUser},
+		Logger:      slog.Default(),
+	})
+
+	if err := client.Forward(context.Background(), &mail.Message{User: "printer"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sentTo != "default-token" {
+		t.Errorf("expected fallback to the default token, got %q", sentTo)
+	}
+}
+
+func TestClient_ForwardProvisionLimits(t *testing.T) {
+	fake := &fakeApps{icons: map[string]string{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	client := NewClient(Config{
+		URL:         server.URL,
+		Tokens:      []string{"default-token"},
+		Renderer:    renderer,
+		ClientToken: "client-token",
+		Provision: Provision{
+			By:          ByRecipient,
+			Allow:       []string{"Alerts", "backup"},
+			MaxApps:     1,
+			Description: "auto",
+		},
+		Logger: slog.Default(),
+	})
+
+	for i, rcpt := range []string{"alerts@example.com", "backup@example.com", "made-up@example.com"} {
+		msg := &mail.Message{ID: fmt.Sprint(i), Recipients: []string{rcpt}}
+		if err := client.Forward(context.Background(), msg); err != nil {
+			t.Fatalf("forward to %s: %v", rcpt, err)
+		}
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if fake.created != 1 {
+		t.Errorf("expected 1 application to be created, got %d", fake.created)
+	}
+	// The limit and the allow-list both fall back to the default token
+	want := []string{"app-alerts", "default-token", "default-token"}
+	if fmt.Sprint(fake.sentTo) != fmt.Sprint(want) {
+		t.Errorf("expected tokens %v, got %v", want, fake.sentTo)
+	}
+}
+
+func TestClient_ForwardProvisionDeletedApp(t *testing.T) {
+	fake := &fakeApps{
+		apps:    []application{{ID: 1, Token: "app-old", Name: "printer"}},
+		icons:   map[string]string{},
+		revoked: map[string]bool{},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	client := NewClient(Config{
+		URL:         server.URL,
+		Tokens:      []string{"default-token"},
+		Renderer:    renderer,
+		ClientToken: "client-token",
+		Provision:   Provision{By: ByUser},
+		Logger:      slog.Default(),
+	})
+	ctx := context.Background()
+
+	if err := client.Forward(ctx, &mail.Message{ID: "1", User: "printer"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The application is deleted in Gotify
+	fake.mu.Lock()
+	fake.apps = nil
+	fake.revoked["app-old"] = true
+	fake.mu.Unlock()
+
+	msg := &mail.Message{ID: "2", User: "printer"}
+	err := client.Forward(ctx, msg)
+	var tokenErr *AppTokenError
+	if !errors.As(err, &tokenErr) || delivery.Permanent(err) {
+		t.Fatalf("expected temporary application token error, got %v", err)
+	}
+	if err := client.Forward(ctx, msg); err != nil {
+		t.Fatalf("expected the retry to re-create the application, got %v", err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.created != 1 {
+		t.Errorf("expected the application to be re-created, got %d created", fake.created)
+	}
+	if want := []string{"app-old", "app-printer"}; fmt.Sprint(fake.sentTo) != fmt.Sprint(want) {
+		t.Errorf("expected tokens %v, got %v", want, fake.sentTo)
+	}
+}
@@ -0,0 +1,87 @@
+package gotify
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/alex/smtp-gotify/internal/delivery"
+	"github.com/alex/smtp-gotify/internal/tlsutil"
+)
+
+// TransportConfig holds the TLS, proxy and connection settings used to reach
+// a Gotify server. The zero value behaves like http.DefaultTransport.
+type TransportConfig struct {
+	// CAFile replaces the system roots with the certificates in this file.
+	CAFile             string
+	ClientCert         string
+	ClientKey          string
+	InsecureSkipVerify bool
+	// Proxy is an http, https or socks5 URL, "direct" to bypass any
+	// proxy, or empty to use HTTP_PROXY and HTTPS_PROXY.
+	Proxy               string
+	MaxIdleConns        int
+	IdleConnTimeout     time.Duration
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+}
+
+func NewTransport(cfg TransportConfig) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pool, err := tlsutil.LoadCertPool(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("load CA file: %w", err)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	t.TLSClientConfig = tlsConfig
+
+	switch cfg.Proxy {
+	case "":
+		t.Proxy = http.ProxyFromEnvironment
+	case "direct":
+		t.Proxy = nil
+	default:
+		proxyURL, err := url.Parse(cfg.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %s", delivery.RedactURL(cfg.Proxy))
+		}
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if cfg.DialTimeout > 0 {
+		t.DialContext = (&net.Dialer{
+			Timeout:   cfg.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	if cfg.TLSHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = cfg.TLSHandshakeTimeout
+	}
+	if cfg.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+	// All requests of a client go to the same host
+	if cfg.MaxIdleConns > 0 {
+		t.MaxIdleConns = cfg.MaxIdleConns
+		t.MaxIdleConnsPerHost = cfg.MaxIdleConns
+	}
+
+	return t, nil
+}
@@ -0,0 +1,97 @@
+package gotify
+
+import (
+	"context"
+	"encoding/pem"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alex/smtp-gotify/internal/mail"
+	"github.com/alex/smtp-gotify/internal/template"
+)
+
+func newTransportClient(t *testing.T, url string, cfg TransportConfig) *Client {
+	t.Helper()
+	transport, err := NewTransport(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	return NewClient(Config{
+		URL:       url,
+		Tokens:    []string{"test-token"},
+		Renderer:  renderer,
+		Transport: transport,
+		Logger:    slog.Default(),
+	})
+}
+
+func TestTransport_CustomCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, certPEM, 0o600); err != nil {
+		t.Fatalf("failed to write CA file: %v", err)
+	}
+
+	msg := &mail.Message{Subject: "Test"}
+
+	client := newTransportClient(t, server.URL, TransportConfig{})
+	if err := client.Forward(context.Background(), msg); err == nil {
+		t.Error("expected certificate error without custom CA")
+	}
+
+	client = newTransportClient(t, server.URL, TransportConfig{CAFile: caFile})
+	if err := client.Forward(context.Background(), msg); err != nil {
+		t.Errorf("unexpected error with custom CA: %v", err)
+	}
+
+	client = newTransportClient(t, server.URL, TransportConfig{InsecureSkipVerify: true})
+	if err := client.Forward(context.Background(), msg); err != nil {
+		t.Errorf("unexpected error with verification disabled: %v", err)
+	}
+}
+
+func TestTransport_Proxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests through a proxy carry the absolute target URL
+		proxied = r.URL.String()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	client := newTransportClient(t, "http://gotify.internal", TransportConfig{Proxy: proxy.URL})
+	if err := client.Forward(context.Background(), &mail.Message{Subject: "Test"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if proxied != "http://gotify.internal/message" {
+		t.Errorf("expected request for gotify.internal through proxy, got %s", proxied)
+	}
+}
+
+func TestTransport_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  TransportConfig
+	}{
+		{"missing CA file", TransportConfig{CAFile: "/nonexistent/ca.pem"}},
+		{"missing client cert", TransportConfig{ClientCert: "/nonexistent/cert.pem", ClientKey: "/nonexistent/key.pem"}},
+		{"invalid proxy", TransportConfig{Proxy: "not a url"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewTransport(tt.cfg); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -5,15 +5,28 @@ import (
 	"encoding/json"
 	"log/slog"
 	"net/http"
+	"sync"
 )
 
 type Response struct {
-	Status string `json:"status"`
+	Status       string            `json:"status"`
+	Destinations map[string]string `json:"destinations,omitempty"`
+}
+
+// Destination reports the state of a delivery destination, such as its
+// circuit breaker.
+type Destination interface {
+	State() string
+	Healthy() bool
 }
 
 type Server struct {
 	server *http.Server
+	mux    *http.ServeMux
 	logger *slog.Logger
+
+	mu           sync.Mutex
+	destinations map[string]Destination
 }
 
 func NewServer(addr string, logger *slog.Logger) *Server {
@@ -23,7 +36,9 @@ func NewServer(addr string, logger *slog.Logger) *Server {
 			Addr:    addr,
 			Handler: mux,
 		},
-		logger: logger,
+		mux:          mux,
+		logger:       logger,
+		destinations: make(map[string]Destination),
 	}
 
 	mux.HandleFunc("/health", s.handleHealth)
@@ -31,14 +46,43 @@ func NewServer(addr string, logger *slog.Logger) *Server {
 	return s
 }
 
+// AddDestination includes the state of a destination in health responses.
+func (s *Server) AddDestination(name string, d Destination) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destinations[name] = d
+}
+
+// handleHealth always answers 200 so that an unreachable Gotify does not get
+// this container restarted; the status is "degraded" instead.
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	resp := Response{Status: "ok"}
+
+	s.mu.Lock()
+	for name, d := range s.destinations {
+		if resp.Destinations == nil {
+			resp.Destinations = make(map[string]string)
+		}
+		resp.Destinations[name] = d.State()
+		if !d.Healthy() {
+			resp.Status = "degraded"
+		}
+	}
+	s.mu.Unlock()
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	if err := json.NewEncoder(w).Encode(Response{Status: "ok"}); err != nil {
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		s.logger.Error("failed to encode health response", "error", err)
 	}
 }
 
+// Handle registers an additional handler, such as the admin API, on the
+// health server.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
 func (s *Server) ListenAndServe() error {
 	s.logger.Info("starting health server", "addr", s.server.Addr)
 	return s.server.ListenAndServe()
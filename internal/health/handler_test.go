@@ -33,3 +33,36 @@ func TestHealthHandler(t *testing.T) {
 		t.Errorf("expected Content-Type application/json, got %s", w.Header().Get("Content-Type"))
 	}
 }
+
+type stubDestination struct {
+	state   string
+	healthy bool
+}
+
+func (d stubDestination) State() string { return d.state }
+func (d stubDestination) Healthy() bool { return d.healthy }
+
+func TestHealthHandler_Destinations(t *testing.T) {
+	s := NewServer(":8080", slog.Default())
+	s.AddDestination("prod", stubDestination{state: "closed", healthy: true})
+	s.AddDestination("home", stubDestination{state: "open"})
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	w := httptest.NewRecorder()
+	s.server.Handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Status != "degraded" {
+		t.Errorf("expected status 'degraded', got %s", resp.Status)
+	}
+	if resp.Destinations["prod"] != "closed" || resp.Destinations["home"] != "open" {
+		t.Errorf("unexpected destinations: %v", resp.Destinations)
+	}
+}
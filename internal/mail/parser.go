@@ -1,17 +1,45 @@
 package mail
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
+	"net/textproto"
+	"strings"
 
 	"github.com/jhillyerd/enmime"
 )
 
 type Message struct {
-	From        string
-	To          []string
-	Subject     string
-	Body        string
+	// ID identifies the message across retries: its Message-ID header, or a
+	// hash of the raw message when there is none.
+	ID string
+	// Hash is the SHA-256 of the raw message, which tells a retry apart from
+	// a different message reusing the same Message-ID.
+	Hash    string
+	From    string
+	To      []string
+	Subject string
+	Body    string
+	// Headers holds the first decoded value of each header, keyed by its
+	// canonical name.
+	Headers     map[string]string
 	Attachments []Attachment
+	// Recipients holds the envelope RCPT TO addresses.
+	Recipients []string
+	// User is the authenticated SMTP username, if any.
+	User string
+	// RemoteAddr is the client IP address, as reported by a trusted proxy
+	// when the PROXY protocol is enabled.
+	RemoteAddr string
+	// Route, when set, names the user or recipient route to deliver to
+	// instead of the one picked from User and Recipients. It is set when a
+	// dead letter is replayed to a different destination, and by the router
+	// to tell destinations which route it resolved.
+	Route string
+	// Delivered lists the destinations the message already reached, so a
+	// retry after a partial failure only targets the remaining ones.
+	Delivered []string
 }
 
 type Attachment struct {
@@ -27,15 +55,34 @@ func NewParser() *Parser {
 }
 
 func (p *Parser) Parse(r io.Reader) (*Message, error) {
-	env, err := enmime.ReadEnvelope(r)
+	hash := sha256.New()
+	tee := io.TeeReader(r, hash)
+
+	env, err := enmime.ReadEnvelope(tee)
 	if err != nil {
 		return nil, err
 	}
+	// Hash the whole message even if the parser stopped early
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return nil, err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	id := strings.TrimSpace(env.GetHeader("Message-ID"))
+	if id == "" {
+		id = sum
+	}
 
 	msg := &Message{
+		ID:      id,
+		Hash:    sum,
 		From:    env.GetHeader("From"),
 		Subject: env.GetHeader("Subject"),
 		To:      parseAddressList(env),
+		Headers: make(map[string]string),
+	}
+	for _, key := range env.GetHeaderKeys() {
+		msg.Headers[textproto.CanonicalMIMEHeaderKey(key)] = env.GetHeader(key)
 	}
 
 	// Prefer plain text, fall back to HTML
@@ -33,6 +33,55 @@ This is the body.`
 	}
 }
 
+func TestParser_ParseHeaders(t *testing.T) {
+	p := NewParser()
+
+	msg, err := p.Parse(strings.NewReader("x-alert-id: cpu-42\nSubject: =?UTF-8?Q?caf=C3=A9?=\n\nBody"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if msg.Headers["X-Alert-Id"] != "cpu-42" {
+		t.Errorf("expected X-Alert-Id cpu-42, got %q", msg.Headers["X-Alert-Id"])
+	}
+	if msg.Headers["Subject"] != "café" {
+		t.Errorf("expected decoded subject, got %q", msg.Headers["Subject"])
+	}
+}
+
+func TestParser_ParseID(t *testing.T) {
+	p := NewParser()
+
+	msg, err := p.Parse(strings.NewReader("Message-ID: <abc@example.com>\nSubject: Hi\n\nBody"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.ID != "<abc@example.com>" {
+		t.Errorf("expected Message-ID as ID, got %s", msg.ID)
+	}
+
+	// A different message reusing the Message-ID keeps the ID but not the hash
+	reused, _ := p.Parse(strings.NewReader("Message-ID: <abc@example.com>\nSubject: Other\n\nBody"))
+	if reused.ID != msg.ID || reused.Hash == "" || reused.Hash == msg.Hash {
+		t.Errorf("expected same ID and different hash, got %q/%q and %q/%q", msg.ID, msg.Hash, reused.ID, reused.Hash)
+	}
+
+	// Without a Message-ID the same content yields the same ID
+	email := "Subject: Hi\n\nBody"
+	first, err := p.Parse(strings.NewReader(email))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, _ := p.Parse(strings.NewReader(email))
+	other, _ := p.Parse(strings.NewReader(email + " changed"))
+	if first.ID == "" || first.ID != second.ID {
+		t.Errorf("expected stable content hash, got %q and %q", first.ID, second.ID)
+	}
+	if first.ID == other.ID {
+		t.Error("expected different content to yield a different ID")
+	}
+}
+
 func TestParser_ParseMultipart(t *testing.T) {
 	p := NewParser()
 
@@ -0,0 +1,217 @@
+package ntfy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alex/smtp-gotify/internal/delivery"
+	"github.com/alex/smtp-gotify/internal/mail"
+	"github.com/alex/smtp-gotify/internal/template"
+)
+
+// Message is the JSON body of an ntfy publish request.
+type Message struct {
+	Topic    string   `json:"topic"`
+	Title    string   `json:"title,omitempty"`
+	Message  string   `json:"message"`
+	Priority int      `json:"priority,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Click    string   `json:"click,omitempty"`
+	Attach   string   `json:"attach,omitempty"`
+	Markdown bool     `json:"markdown,omitempty"`
+}
+
+// Priority maps the Gotify scale of 0 to 10 onto ntfy's 1 (min) to 5 (max),
+// following the Gotify client's grouping: 0 is silent, 1-3 low, 4-7 normal
+// and 8-10 high, with 10 as urgent.
+func Priority(p int) int {
+	switch {
+	case p <= 0:
+		return 1
+	case p <= 3:
+		return 2
+	case p <= 7:
+		return 3
+	case p <= 9:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// Client publishes messages to one or more ntfy topics.
+type Client struct {
+	baseURL  string
+	topics   []string
+	auth     string
+	priority int
+	tags     []string
+	click    *template.Text
+	attach   *template.Text
+	markdown bool
+	renderer *template.Renderer
+	sent     *delivery.SentCache
+	http     *http.Client
+	logger   *slog.Logger
+}
+
+type Config struct {
+	// URL is the ntfy server, e.g. https://ntfy.sh.
+	URL    string
+	Topics []string
+	// Token, or Username and Password, authenticate against protected
+	// topics.
+	Token    string
+	Username string
+	Password string
+	// Priority is on the Gotify scale of 0 to 10, see Priority.
+	Priority int
+	Tags     []string
+	// Click and Attach, if set, render the URL opened on tap and the URL
+	// of a file to attach. Empty results are left out.
+	Click    *template.Text
+	Attach   *template.Text
+	Markdown bool
+	Renderer *template.Renderer
+	// Timeout bounds a single request, 0 means 30s.
+	Timeout   time.Duration
+	Transport http.RoundTripper
+	Logger    *slog.Logger
+}
+
+func NewClient(cfg Config) *Client {
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	var auth string
+	switch {
+	case cfg.Token != "":
+		auth = "Bearer " + cfg.Token
+	case cfg.Username != "":
+		auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(cfg.Username+":"+cfg.Password))
+	}
+
+	return &Client{
+		baseURL:  strings.TrimSuffix(cfg.URL, "/"),
+		topics:   cfg.Topics,
+		auth:     auth,
+		priority: Priority(cfg.Priority),
+		tags:     cfg.Tags,
+		click:    cfg.Click,
+		attach:   cfg.Attach,
+		markdown: cfg.Markdown,
+		renderer: cfg.Renderer,
+		sent:     delivery.NewSentCache(delivery.SentTTL),
+		http: &http.Client{
+			Timeout:   timeout,
+			Transport: cfg.Transport,
+		},
+		logger: cfg.Logger,
+	}
+}
+
+func (c *Client) Forward(ctx context.Context, msg *mail.Message) error {
+	title, body, err := c.renderer.Render(msg)
+	if err != nil {
+		return &delivery.TemplateError{Err: err}
+	}
+
+	ntfyMsg := Message{
+		Title:    title,
+		Message:  body,
+		Priority: c.priority,
+		Tags:     c.tags,
+		Markdown: c.markdown,
+	}
+	if c.click != nil {
+		if ntfyMsg.Click, err = c.renderURL(c.click, msg); err != nil {
+			return &delivery.TemplateError{Err: fmt.Errorf("click URL: %w", err)}
+		}
+	}
+	if c.attach != nil {
+		if ntfyMsg.Attach, err = c.renderURL(c.attach, msg); err != nil {
+			return &delivery.TemplateError{Err: fmt.Errorf("attachment URL: %w", err)}
+		}
+	}
+
+	var errs []error
+	for _, topic := range c.topics {
+		dest := c.destination(topic)
+		if slices.Contains(msg.Delivered, dest) || c.sent.Has(msg, dest) {
+			c.logger.Debug("skipping topic already delivered", "id", msg.ID, "topic", topic)
+			continue
+		}
+
+		ntfyMsg.Topic = topic
+		if err := c.publish(ctx, ntfyMsg); err != nil {
+			c.logger.Warn("ntfy delivery failed", "id", msg.ID, "topic", topic, "error", err)
+			errs = append(errs, fmt.Errorf("topic %s: %w", topic, err))
+			continue
+		}
+		c.logger.Debug("ntfy delivery succeeded", "id", msg.ID, "topic", topic)
+		msg.Delivered = append(msg.Delivered, dest)
+		c.sent.Add(msg, dest)
+	}
+
+	if len(errs) > 0 {
+		return &delivery.Error{
+			Delivered: len(c.topics) - len(errs),
+			Total:     len(c.topics),
+			Err:       errors.Join(errs...),
+		}
+	}
+	return nil
+}
+
+func (c *Client) renderURL(t *template.Text, msg *mail.Message) (string, error) {
+	s, err := t.Render(msg)
+	return strings.TrimSpace(s), err
+}
+
+// destination returns a stable key for a topic on this server.
+func (c *Client) destination(topic string) string {
+	sum := sha256.Sum256([]byte(c.baseURL + "\n" + topic))
+	return "ntfy:" + hex.EncodeToString(sum[:8])
+}
+
+func (c *Client) publish(ctx context.Context, m Message) error {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("marshal message: %w", err)
+	}
+
+	// JSON messages are published to the server root, naming the topic in
+	// the body
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/", bytes.NewReader(payload))
+	if err != nil {
+		return delivery.Redact(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.auth != "" {
+		req.Header.Set("Authorization", c.auth)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return &delivery.RequestError{Err: delivery.Redact(err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return delivery.NewStatusError(resp)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package ntfy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/alex/smtp-gotify/internal/delivery"
+	"github.com/alex/smtp-gotify/internal/mail"
+	"github.com/alex/smtp-gotify/internal/template"
+)
+
+func TestClient_Forward(t *testing.T) {
+	var mu sync.Mutex
+	var received []Message
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var m Message
+		_ = json.NewDecoder(r.Body).Decode(&m)
+		received = append(received, m)
+		auth = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	click, _ := template.NewText("click", `https://grafana.example.com/d/{{.Header "X-Dashboard"}}`)
+	attach, _ := template.NewText("attach", `{{.Header "X-Graph-URL"}}`)
+	client := NewClient(Config{
+		URL:      server.URL + "/",
+		Topics:   []string{"alerts", "backup"},
+		Token:    "tk_secret",
+		Priority: 8,
+		Tags:     []string{"warning", "email"},
+		Click:    click,
+		Attach:   attach,
+		Markdown: true,
+		Renderer: renderer,
+		Logger:   slog.Default(),
+	})
+
+	msg := &mail.Message{
+		Subject: "Disk full",
+		Body:    "**sda** at 98%",
+		Headers: map[string]string{"X-Dashboard": "disks"},
+	}
+	if err := client.Forward(context.Background(), msg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(received) != 2 || received[0].Topic != "alerts" || received[1].Topic != "backup" {
+		t.Fatalf("expected one message per topic, got %+v", received)
+	}
+	m := received[0]
+	if m.Title != "Disk full" || m.Priority != 4 || !m.Markdown || len(m.Tags) != 2 {
+		t.Errorf("unexpected message: %+v", m)
+	}
+	if m.Click != "https://grafana.example.com/d/disks" {
+		t.Errorf("unexpected click URL %q", m.Click)
+	}
+	if m.Attach != "" {
+		t.Errorf("expected empty attachment to be left out, got %q", m.Attach)
+	}
+	if auth != "Bearer tk_secret" {
+		t.Errorf("expected bearer auth, got %q", auth)
+	}
+}
+
+func TestClient_ForwardPartialRetry(t *testing.T) {
+	var mu sync.Mutex
+	counts := map[string]int{}
+	failBackup := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var m Message
+		_ = json.NewDecoder(r.Body).Decode(&m)
+		if m.Topic == "backup" && failBackup {
+			failBackup = false
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		counts[m.Topic]++
+	}))
+	defer server.Close()
+
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	client := NewClient(Config{
+		URL:      server.URL,
+		Topics:   []string{"alerts", "backup"},
+		Renderer: renderer,
+		Logger:   slog.Default(),
+	})
+
+	msg := &mail.Message{ID: "<1@example.com>", Subject: "Hi"}
+	err := client.Forward(context.Background(), msg)
+	var deliveryErr *delivery.Error
+	if !errors.As(err, &deliveryErr) || !deliveryErr.Partial() || delivery.Permanent(err) {
+		t.Fatalf("expected temporary partial failure, got %v", err)
+	}
+	if err := client.Forward(context.Background(), msg); err != nil {
+		t.Fatalf("unexpected error on retry: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if counts["alerts"] != 1 || counts["backup"] != 1 {
+		t.Errorf("expected each topic to be notified once, got %v", counts)
+	}
+}
+
+func TestClient_ForwardTemplateError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("unexpected request for a message that cannot be rendered")
+	}))
+	defer server.Close()
+
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	click, _ := template.NewText("click", `{{match "(" .Subject}}`)
+	client := NewClient(Config{
+		URL:      server.URL,
+		Topics:   []string{"alerts"},
+		Click:    click,
+		Renderer: renderer,
+		Logger:   slog.Default(),
+	})
+
+	err := client.Forward(context.Background(), &mail.Message{Subject: "Test"})
+	var templateErr *delivery.TemplateError
+	if !errors.As(err, &templateErr) || !delivery.Permanent(err) {
+		t.Errorf("expected permanent template error, got %v", err)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	tests := map[int]int{0: 1, 1: 2, 3: 2, 4: 3, 5: 3, 7: 3, 8: 4, 9: 4, 10: 5}
+	for in, want := range tests {
+		if got := Priority(in); got != want {
+			t.Errorf("Priority(%d) = %d, want %d", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const defaultHeaderTimeout = 5 * time.Second
+
+// Listener accepts connections carrying a PROXY protocol header. Headers are
+// only honoured from peers in Trusted; other peers are passed through
+// unchanged. Headers are read in the background so a slow proxy cannot stall
+// Accept.
+type Listener struct {
+	net.Listener
+	Trusted       []netip.Prefix
+	HeaderTimeout time.Duration
+	Logger        *slog.Logger
+
+	startOnce sync.Once
+	closeOnce sync.Once
+	accepted  chan accepted
+	done      chan struct{}
+}
+
+type accepted struct {
+	conn net.Conn
+	err  error
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	l.startOnce.Do(func() {
+		l.accepted = make(chan accepted)
+		l.done = make(chan struct{})
+		go l.acceptLoop()
+	})
+
+	select {
+	case a := <-l.accepted:
+		return a.conn, a.err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *Listener) Close() error {
+	l.startOnce.Do(func() {
+		l.done = make(chan struct{})
+	})
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// acceptLoop hands accepted connections to Accept. Temporary errors, such as
+// running out of file descriptors, are reported without ending the loop so
+// the server can back off and call Accept again.
+func (l *Listener) acceptLoop() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.accepted <- accepted{err: err}:
+			case <-l.done:
+				return
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() {
+				continue
+			}
+			return
+		}
+
+		if !l.trusted(c.RemoteAddr()) {
+			l.deliver(c)
+			continue
+		}
+
+		go func() {
+			pc, err := l.readHeader(c)
+			if err != nil {
+				l.Logger.Warn("invalid PROXY protocol header", "remote", c.RemoteAddr(), "error", err)
+				c.Close()
+				return
+			}
+			l.deliver(pc)
+		}()
+	}
+}
+
+func (l *Listener) deliver(c net.Conn) {
+	select {
+	case l.accepted <- accepted{conn: c}:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+func (l *Listener) readHeader(c net.Conn) (net.Conn, error) {
+	timeout := l.HeaderTimeout
+	if timeout == 0 {
+		timeout = defaultHeaderTimeout
+	}
+	if err := c.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(c)
+	addr, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	if addr == nil {
+		addr = c.RemoteAddr()
+	}
+	return &Conn{Conn: c, r: r, remote: addr}, nil
+}
+
+func (l *Listener) trusted(addr net.Addr) bool {
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := addrPort.Addr().Unmap()
+	for _, p := range l.Trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn reports the client address announced by the proxy and serves any
+// bytes buffered while reading the header.
+type Conn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
@@ -0,0 +1,201 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadHeader_V1(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PROXY TCP4 203.0.113.7 192.0.2.1 51234 25\r\nEHLO"))
+	addr, err := readHeader(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if addr.String() != "203.0.113.7:51234" {
+		t.Errorf("expected 203.0.113.7:51234, got %s", addr)
+	}
+
+	rest, _ := io.ReadAll(r)
+	if string(rest) != "EHLO" {
+		t.Errorf("expected remaining data to be preserved, got %q", rest)
+	}
+}
+
+func TestReadHeader_V1Unknown(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PROXY UNKNOWN\r\n"))
+	addr, err := readHeader(r)
+	if err != nil || addr != nil {
+		t.Errorf("expected nil address for UNKNOWN, got %v, %v", addr, err)
+	}
+}
+
+func TestReadHeader_V2(t *testing.T) {
+	var hdr []byte
+	hdr = append(hdr, v2Signature...)
+	hdr = append(hdr, 0x21, 0x21) // v2 PROXY, TCP over IPv6
+	hdr = binary.BigEndian.AppendUint16(hdr, 36)
+	src := netip.MustParseAddr("2001:db8::7").As16()
+	dst := netip.MustParseAddr("2001:db8::1").As16()
+	hdr = append(hdr, src[:]...)
+	hdr = append(hdr, dst[:]...)
+	hdr = binary.BigEndian.AppendUint16(hdr, 40000)
+	hdr = binary.BigEndian.AppendUint16(hdr, 465)
+
+	addr, err := readHeader(bufio.NewReader(strings.NewReader(string(hdr))))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if addr.String() != "[2001:db8::7]:40000" {
+		t.Errorf("expected [2001:db8::7]:40000, got %s", addr)
+	}
+}
+
+func TestReadHeader_Invalid(t *testing.T) {
+	for _, input := range []string{
+		"EHLO example.com\r\n",
+		"PROXY TCP4 not-an-ip 192.0.2.1 1 25\r\n",
+		"PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n",
+	} {
+		if _, err := readHeader(bufio.NewReader(strings.NewReader(input))); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestListener(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	l := &Listener{
+		Listener:      raw,
+		Trusted:       []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+		HeaderTimeout: time.Second,
+		Logger:        slog.Default(),
+	}
+	defer l.Close()
+
+	// A stalled proxy connection must not block the next one
+	stalled, err := net.Dial("tcp", raw.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer stalled.Close()
+
+	client, err := net.Dial("tcp", raw.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("PROXY TCP4 198.51.100.9 127.0.0.1 4000 25\r\nHELLO")); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+
+	if c.RemoteAddr().String() != "198.51.100.9:4000" {
+		t.Errorf("expected proxied address, got %s", c.RemoteAddr())
+	}
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "HELLO" {
+		t.Errorf("expected HELLO after header, got %q, %v", buf, err)
+	}
+}
+
+func TestListener_Untrusted(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	l := &Listener{
+		Listener: raw,
+		Trusted:  []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+		Logger:   slog.Default(),
+	}
+	defer l.Close()
+
+	client, err := net.Dial("tcp", raw.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer client.Close()
+
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+
+	if c.RemoteAddr().String() != client.LocalAddr().String() {
+		t.Errorf("expected untrusted peer address to be kept, got %s", c.RemoteAddr())
+	}
+}
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// flakyListener fails its first Accept with a temporary error.
+type flakyListener struct {
+	net.Listener
+	failed bool
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if !l.failed {
+		l.failed = true
+		return nil, temporaryError{}
+	}
+	return l.Listener.Accept()
+}
+
+func TestListener_TemporaryError(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	l := &Listener{Listener: &flakyListener{Listener: raw}, Logger: slog.Default()}
+	defer l.Close()
+
+	if _, err := l.Accept(); err == nil {
+		t.Fatal("expected the temporary error to be reported")
+	}
+
+	client, err := net.Dial("tcp", raw.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer client.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			c.Close()
+		}
+		accepted <- err
+	}()
+
+	select {
+	case err := <-accepted:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Accept blocked after a temporary error")
+	}
+}
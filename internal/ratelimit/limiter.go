@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limiter is a set of token buckets keyed by an arbitrary string such as an
+// IP address or username. Each bucket holds up to Limit tokens and refills
+// at Limit tokens per Per.
+type Limiter struct {
+	Limit int
+	Per   time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func New(limit int, per time.Duration) *Limiter {
+	return &Limiter{
+		Limit:   limit,
+		Per:     per,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Parse builds a limiter from a "<count>/<duration>" spec such as "30/1m".
+// An empty spec returns a nil limiter, which allows everything.
+func Parse(spec string) (*Limiter, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	countStr, perStr, ok := strings.Cut(spec, "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid rate %q: expected <count>/<duration>", spec)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(countStr))
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("invalid rate %q: count must be a positive integer", spec)
+	}
+	per, err := time.ParseDuration(strings.TrimSpace(perStr))
+	if err != nil || per <= 0 {
+		return nil, fmt.Errorf("invalid rate %q: duration must be positive", spec)
+	}
+
+	return New(count, per), nil
+}
+
+// Allow takes a token from the bucket for key and reports whether one was
+// available.
+func (l *Limiter) Allow(key string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Limit), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Refund returns a token taken by Allow, for a request that was rejected by
+// a later check and should not count against key.
+func (l *Limiter) Refund(key string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if b, ok := l.buckets[key]; ok {
+		b.tokens = min(float64(l.Limit), b.tokens+1)
+	}
+}
+
+func (l *Limiter) String() string {
+	if l == nil {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d/%s", l.Limit, l.Per)
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	rate := float64(l.Limit) / l.Per.Seconds()
+	return min(float64(l.Limit), b.tokens+now.Sub(b.last).Seconds()*rate)
+}
+
+// sweep drops buckets that have refilled completely, since they behave the
+// same as a new bucket. It runs at most once per refill period.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.Per {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.Limit) {
+			delete(l.buckets, key)
+		}
+	}
+}
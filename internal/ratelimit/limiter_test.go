@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	now := time.Now()
+	l := New(2, time.Minute)
+	l.now = func() time.Time { return now }
+
+	if !l.Allow("a") || !l.Allow("a") {
+		t.Fatal("expected burst of 2 to be allowed")
+	}
+	if l.Allow("a") {
+		t.Fatal("expected third request to be limited")
+	}
+	if !l.Allow("b") {
+		t.Fatal("expected other key to have its own bucket")
+	}
+
+	now = now.Add(30 * time.Second)
+	if !l.Allow("a") {
+		t.Fatal("expected one token to refill after half the period")
+	}
+	if l.Allow("a") {
+		t.Fatal("expected bucket to be empty again")
+	}
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	now := time.Now()
+	l := New(1, time.Second)
+	l.now = func() time.Time { return now }
+
+	l.Allow("a")
+	now = now.Add(2 * time.Second)
+	l.Allow("b")
+
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("expected refilled bucket to be swept")
+	}
+}
+
+func TestParse(t *testing.T) {
+	l, err := Parse("30/1m")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if l.Limit != 30 || l.Per != time.Minute {
+		t.Errorf("unexpected limiter: %s", l)
+	}
+
+	if l, err := Parse(""); err != nil || l != nil {
+		t.Errorf("expected nil limiter for empty spec, got %v, %v", l, err)
+	}
+	if !(*Limiter)(nil).Allow("a") {
+		t.Error("expected nil limiter to allow everything")
+	}
+
+	for _, spec := range []string{"30", "0/1m", "x/1m", "30/soon", "30/-1s"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("expected error for %q", spec)
+		}
+	}
+}
+
+func TestLimiter_Refund(t *testing.T) {
+	now := time.Now()
+	l := New(1, time.Hour)
+	l.now = func() time.Time { return now }
+
+	if !l.Allow("a") {
+		t.Fatal("expected first request to be allowed")
+	}
+	l.Refund("a")
+	l.Refund("a")
+	if !l.Allow("a") {
+		t.Fatal("expected refunded token to be available")
+	}
+	if l.Allow("a") {
+		t.Fatal("expected refunds to be capped at the limit")
+	}
+}
@@ -0,0 +1,92 @@
+package smtp
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// accessList implements connection-level IP filtering. Denied networks
+// always win; when an allow list is set, only listed or trusted networks may
+// connect. Clients in trusted networks may send without authenticating.
+type accessList struct {
+	allow   []netip.Prefix
+	deny    []netip.Prefix
+	trusted []netip.Prefix
+}
+
+func newAccessList(allow, deny, trusted []string) (*accessList, error) {
+	var a accessList
+	var err error
+	if a.allow, err = parsePrefixes(allow); err != nil {
+		return nil, err
+	}
+	if a.deny, err = parsePrefixes(deny); err != nil {
+		return nil, err
+	}
+	if a.trusted, err = parsePrefixes(trusted); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// check reports whether a client may connect and whether it is trusted.
+// Connections without an IP address, such as Unix sockets, are not subject
+// to the network lists; use the listener's auth setting to protect them.
+func (a *accessList) check(addr net.Addr) (allowed, trusted bool) {
+	ip, ok := addrIP(addr)
+	if !ok {
+		return true, false
+	}
+
+	if containsIP(a.deny, ip) {
+		return false, false
+	}
+	trusted = containsIP(a.trusted, ip)
+	if len(a.allow) > 0 && !trusted && !containsIP(a.allow, ip) {
+		return false, false
+	}
+	return true, trusted
+}
+
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	if addr == nil {
+		return netip.Addr{}, false
+	}
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addrPort.Addr().Unmap(), true
+}
+
+func containsIP(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefixes accepts CIDR ranges as well as single IP addresses.
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			ip, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q: %w", v, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", v, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
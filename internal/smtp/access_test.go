@@ -0,0 +1,57 @@
+package smtp
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAccessList(t *testing.T) {
+	a, err := newAccessList(
+		[]string{"192.168.1.0/24"},
+		[]string{"192.168.1.13"},
+		[]string{"10.0.0.0/8"},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		addr        net.Addr
+		wantAllowed bool
+		wantTrusted bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.20"), Port: 40000}, true, false},
+		{&net.TCPAddr{IP: net.ParseIP("192.168.1.13"), Port: 40000}, false, false},
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 40000}, true, true},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:10.1.2.3"), Port: 40000}, true, true},
+		{&net.TCPAddr{IP: net.ParseIP("203.0.113.5"), Port: 40000}, false, false},
+		{&net.UnixAddr{Name: "@", Net: "unix"}, true, false},
+	}
+
+	for _, tt := range tests {
+		allowed, trusted := a.check(tt.addr)
+		if allowed != tt.wantAllowed || trusted != tt.wantTrusted {
+			t.Errorf("check(%s) = %v, %v; want %v, %v", tt.addr, allowed, trusted, tt.wantAllowed, tt.wantTrusted)
+		}
+	}
+}
+
+func TestAccessList_NoAllowList(t *testing.T) {
+	a, err := newAccessList(nil, []string{"203.0.113.0/24"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if allowed, trusted := a.check(&net.TCPAddr{IP: net.ParseIP("198.51.100.1")}); !allowed || trusted {
+		t.Errorf("expected untrusted allowed client, got allowed=%v trusted=%v", allowed, trusted)
+	}
+	if allowed, _ := a.check(&net.TCPAddr{IP: net.ParseIP("203.0.113.9")}); allowed {
+		t.Error("expected denied client")
+	}
+}
+
+func TestAccessList_Invalid(t *testing.T) {
+	if _, err := newAccessList([]string{"not-an-ip"}, nil, nil); err == nil {
+		t.Error("expected error for invalid network")
+	}
+}
@@ -3,7 +3,10 @@ package smtp
 import (
 	"context"
 	"log/slog"
+	"time"
 
+	"github.com/alex/smtp-gotify/internal/auth"
+	"github.com/alex/smtp-gotify/internal/config"
 	"github.com/alex/smtp-gotify/internal/mail"
 	"github.com/emersion/go-smtp"
 )
@@ -12,23 +15,93 @@ type Forwarder interface {
 	Forward(ctx context.Context, msg *mail.Message) error
 }
 
+// routeMatcher is implemented by forwarders that can tell which route a
+// message takes, such as delivery.Router. LMTP uses it to deliver once per
+// route instead of once per recipient.
+type routeMatcher interface {
+	Match(msg *mail.Message) string
+}
+
 type Backend struct {
-	logger    *slog.Logger
-	parser    *mail.Parser
-	forwarder Forwarder
+	ctx         context.Context
+	logger      *slog.Logger
+	parser      *mail.Parser
+	forwarder   Forwarder
+	auth        auth.Authenticator
+	recipients  *recipientPolicy
+	access      *accessList
+	limits      *rateLimits
+	dataTimeout time.Duration
+	// deliveryTimeout bounds each Forward call; zero means no deadline
+	deliveryTimeout time.Duration
+	deliveries      *deliveryTracker
 }
 
 func NewBackend(logger *slog.Logger, parser *mail.Parser, forwarder Forwarder) *Backend {
 	return &Backend{
-		logger:    logger,
-		parser:    parser,
-		forwarder: forwarder,
+		ctx:        context.Background(),
+		deliveries: newDeliveryTracker(),
+		logger:     logger,
+		parser:     parser,
+		forwarder:  forwarder,
 	}
 }
 
+var errAccessDenied = &smtp.SMTPError{
+	Code:         554,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+	Message:      "Access denied",
+}
+
 func (b *Backend) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	return b.newSession(c, config.ListenerConfig{})
+}
+
+// forListener returns a go-smtp backend that applies the listener's
+// requirements to every session.
+func (b *Backend) forListener(l config.ListenerConfig) smtp.Backend {
+	return smtp.BackendFunc(func(c *smtp.Conn) (smtp.Session, error) {
+		return b.newSession(c, l)
+	})
+}
+
+func (b *Backend) newSession(c *smtp.Conn, l config.ListenerConfig) (smtp.Session, error) {
+	session := NewSession(b.logger, b.parser, b.forwarder)
+	session.ctx, session.cancel = context.WithCancel(b.ctx)
+	session.auth = b.auth
+	session.recipients = b.recipients
+	session.limits = b.limits
+	session.dataTimeout = b.dataTimeout
+	session.deliveryTimeout = b.deliveryTimeout
+	session.deliveries = b.deliveries
+	session.authRequired = l.AuthRequired
+	session.tlsRequired = l.TLSRequired
+
 	if c != nil {
-		b.logger.Debug("new session", "remote", c.Conn().RemoteAddr())
+		session.conn = c.Conn()
+		remote := session.conn.RemoteAddr()
+		ip, hasIP := addrIP(remote)
+		if hasIP {
+			session.remoteIP = ip.String()
+		}
+		if b.access != nil {
+			var allowed bool
+			allowed, session.trusted = b.access.check(remote)
+			if !allowed {
+				session.cancel()
+				b.logger.Warn("connection denied", "listener", l.Name, "remote", remote)
+				return nil, errAccessDenied
+			}
+			// With trusted networks configured, everyone else must
+			// authenticate. Unix sockets are left to the listener's setting.
+			if hasIP && len(b.access.trusted) > 0 && !session.trusted {
+				session.authRequired = true
+			}
+		}
+
+		_, session.tls = c.TLSConnectionState()
+		b.logger.Debug("new session", "listener", l.Name, "remote", remote, "tls", session.tls, "trusted", session.trusted)
 	}
-	return NewSession(b.logger, b.parser, b.forwarder), nil
+
+	return session, nil
 }
@@ -2,11 +2,16 @@ package smtp
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/alex/smtp-gotify/internal/auth"
 	"github.com/alex/smtp-gotify/internal/mail"
+	"github.com/alex/smtp-gotify/internal/ratelimit"
+	"github.com/emersion/go-smtp"
 )
 
 type mockForwarder struct {
@@ -49,6 +54,54 @@ Hello world`
 	}
 }
 
+type partialError struct{}
+
+func (partialError) Error() string { return "failed to send to 1/2 tokens" }
+func (partialError) Partial() bool { return true }
+
+func TestSession_DataPartialDelivery(t *testing.T) {
+	forwarder := &mockForwarder{err: partialError{}}
+	session := NewSession(slog.Default(), mail.NewParser(), forwarder)
+
+	_ = session.Mail("sender@example.com", nil)
+	_ = session.Rcpt("recipient@example.com", nil)
+
+	err := session.Data(strings.NewReader("Subject: Test\r\n\r\nHello"))
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 451 {
+		t.Errorf("expected 451 for partial delivery, got %v", err)
+	}
+}
+
+type rejectedError struct{}
+
+func (rejectedError) Error() string   { return "unexpected status: 401 Unauthorized" }
+func (rejectedError) Temporary() bool { return false }
+
+func TestSession_DataErrorClassification(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"temporary", errors.New("connection refused"), 451},
+		{"permanent", rejectedError{}, 554},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := NewSession(slog.Default(), mail.NewParser(), &mockForwarder{err: tt.err})
+			_ = session.Mail("sender@example.com", nil)
+			_ = session.Rcpt("recipient@example.com", nil)
+
+			err := session.Data(strings.NewReader("Subject: Test\r\n\r\nHello"))
+			var smtpErr *smtp.SMTPError
+			if !errors.As(err, &smtpErr) || smtpErr.Code != tt.code {
+				t.Errorf("expected %d, got %v", tt.code, err)
+			}
+		})
+	}
+}
+
 func TestSession_Reset(t *testing.T) {
 	forwarder := &mockForwarder{}
 	parser := mail.NewParser()
@@ -96,6 +149,49 @@ Body only`
 	}
 }
 
+func TestSession_Auth(t *testing.T) {
+	session := NewSession(slog.Default(), mail.NewParser(), &mockForwarder{})
+	session.auth = auth.Users{"printer": "plain:secret"}
+	session.authRequired = true
+
+	if mechs := session.AuthMechanisms(); len(mechs) != 1 || mechs[0] != "PLAIN" {
+		t.Fatalf("expected PLAIN mechanism, got %v", mechs)
+	}
+
+	if err := session.Mail("sender@example.com", nil); !errors.Is(err, errAuthRequired) {
+		t.Fatalf("expected auth required error before AUTH, got %v", err)
+	}
+
+	server, err := session.Auth("PLAIN")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, err := server.Next([]byte("\x00printer\x00wrong")); err != smtp.ErrAuthFailed {
+		t.Fatalf("expected ErrAuthFailed, got %v", err)
+	}
+
+	server, _ = session.Auth("PLAIN")
+	if _, done, err := server.Next([]byte("\x00printer\x00secret")); err != nil || !done {
+		t.Fatalf("expected successful auth, got done=%v err=%v", done, err)
+	}
+
+	if err := session.Mail("sender@example.com", nil); err != nil {
+		t.Errorf("unexpected error after AUTH: %v", err)
+	}
+}
+
+func TestSession_AuthDisabled(t *testing.T) {
+	session := NewSession(slog.Default(), mail.NewParser(), &mockForwarder{})
+
+	if mechs := session.AuthMechanisms(); len(mechs) != 0 {
+		t.Errorf("expected no mechanisms without authenticator, got %v", mechs)
+	}
+
+	if _, err := session.Auth("PLAIN"); err == nil {
+		t.Error("expected error without authenticator")
+	}
+}
+
 func TestBackend_NewSession(t *testing.T) {
 	forwarder := &mockForwarder{}
 	parser := mail.NewParser()
@@ -110,3 +206,134 @@ func TestBackend_NewSession(t *testing.T) {
 		t.Error("expected session to be created")
 	}
 }
+
+func TestSession_TLSRequired(t *testing.T) {
+	session := NewSession(slog.Default(), mail.NewParser(), &mockForwarder{})
+	session.tlsRequired = true
+
+	if err := session.Mail("sender@example.com", nil); !errors.Is(err, errTLSRequired) {
+		t.Fatalf("expected TLS required error without STARTTLS, got %v", err)
+	}
+
+	session.tls = true
+	if err := session.Mail("sender@example.com", nil); err != nil {
+		t.Errorf("unexpected error over TLS: %v", err)
+	}
+}
+
+// recipientForwarder routes recipients by domain and fails for one of them.
+type recipientForwarder struct {
+	fail  string
+	calls [][]string
+}
+
+func (f *recipientForwarder) Forward(ctx context.Context, msg *mail.Message) error {
+	f.calls = append(f.calls, msg.Recipients)
+	if f.Match(msg) == f.fail {
+		return errors.New("gotify unavailable")
+	}
+	return nil
+}
+
+func (f *recipientForwarder) Match(msg *mail.Message) string {
+	_, domain, _ := strings.Cut(msg.Recipients[0], "@")
+	return domain
+}
+
+type statusMap map[string]error
+
+func (m statusMap) SetStatus(rcpt string, err error) {
+	m[rcpt] = err
+}
+
+func TestSession_LMTPData(t *testing.T) {
+	forwarder := &recipientForwarder{fail: "broken.example.com"}
+	session := NewSession(slog.Default(), mail.NewParser(), forwarder)
+
+	_ = session.Mail("sender@example.com", nil)
+	_ = session.Rcpt("alerts@example.com", nil)
+	_ = session.Rcpt("broken@broken.example.com", nil)
+	_ = session.Rcpt("ops@example.com", nil)
+
+	status := statusMap{}
+	err := session.LMTPData(strings.NewReader("Subject: Test\n\nBody"), status)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(forwarder.calls) != 2 || len(forwarder.calls[0]) != 2 {
+		t.Errorf("expected one delivery per route, got %v", forwarder.calls)
+	}
+	for _, rcpt := range []string{"alerts@example.com", "ops@example.com"} {
+		if err, ok := status[rcpt]; !ok || err != nil {
+			t.Errorf("expected success for %s, got %v", rcpt, err)
+		}
+	}
+	if status["broken@broken.example.com"] == nil {
+		t.Error("expected failure for broken@broken.example.com")
+	}
+}
+
+func TestSession_RcptPolicy(t *testing.T) {
+	session := NewSession(slog.Default(), mail.NewParser(), &mockForwarder{})
+	session.recipients, _ = newRecipientPolicy([]string{"example.com"}, nil)
+
+	_ = session.Mail("sender@example.com", nil)
+
+	if err := session.Rcpt("alerts@example.com", nil); err != nil {
+		t.Errorf("unexpected error for accepted domain: %v", err)
+	}
+	if err := session.Rcpt("typo@exmaple.com", nil); !errors.Is(err, errRecipientRejected) {
+		t.Errorf("expected rejection for unknown domain, got %v", err)
+	}
+
+	if len(session.to) != 1 {
+		t.Errorf("expected only accepted recipient to be recorded, got %v", session.to)
+	}
+}
+
+func TestSession_TrustedSkipsAuth(t *testing.T) {
+	session := NewSession(slog.Default(), mail.NewParser(), &mockForwarder{})
+	session.auth = auth.Users{"printer": "plain:secret"}
+	session.authRequired = true
+	session.trusted = true
+
+	if err := session.Mail("sender@example.com", nil); err != nil {
+		t.Errorf("expected trusted client to send without AUTH, got %v", err)
+	}
+}
+
+func TestSession_RateLimit(t *testing.T) {
+	newSession := func(ip, user string) *Session {
+		session := NewSession(slog.Default(), mail.NewParser(), &mockForwarder{})
+		session.limits = &rateLimits{
+			ip:     ratelimit.New(2, time.Hour),
+			user:   ratelimit.New(1, time.Hour),
+			sender: ratelimit.New(1, time.Hour),
+		}
+		session.remoteIP = ip
+		session.user = user
+		return session
+	}
+
+	session := newSession("192.0.2.1", "printer")
+	if err := session.Mail("a@example.com", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := session.Mail("b@example.com", nil); !errors.Is(err, errRateLimited) {
+		t.Errorf("expected per-user limit, got %v", err)
+	}
+
+	session = newSession("192.0.2.1", "")
+	if err := session.Mail("c@example.com", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := session.Mail("C@example.com", nil); !errors.Is(err, errRateLimited) {
+		t.Errorf("expected per-sender limit, got %v", err)
+	}
+
+	// Rejected messages must not use up the per-IP allowance
+	if err := session.Mail("d@example.com", nil); err != nil {
+		t.Errorf("expected rejected messages to be refunded to the IP bucket, got %v", err)
+	}
+}
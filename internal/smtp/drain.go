@@ -0,0 +1,67 @@
+package smtp
+
+import (
+	"context"
+	"sync"
+)
+
+// deliveryTracker counts DATA transactions in progress so shutdown can wait
+// for them. Once draining starts, new transactions are refused. A nil
+// tracker never refuses and never waits.
+type deliveryTracker struct {
+	mu       sync.Mutex
+	active   int
+	draining bool
+	idle     chan struct{}
+}
+
+func newDeliveryTracker() *deliveryTracker {
+	return &deliveryTracker{idle: make(chan struct{})}
+}
+
+func (t *deliveryTracker) begin() bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		return false
+	}
+	t.active++
+	return true
+}
+
+func (t *deliveryTracker) end() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.active--
+	if t.draining && t.active == 0 {
+		close(t.idle)
+	}
+}
+
+// drain refuses new transactions and waits until the active ones finish or
+// ctx is done.
+func (t *deliveryTracker) drain(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.draining {
+		t.draining = true
+		if t.active == 0 {
+			close(t.idle)
+		}
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-t.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,55 @@
+package smtp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDeliveryTracker_Drain(t *testing.T) {
+	tr := newDeliveryTracker()
+
+	if !tr.begin() {
+		t.Fatal("expected transaction to start")
+	}
+
+	drained := make(chan error, 1)
+	go func() { drained <- tr.drain(context.Background()) }()
+
+	// Wait until draining has started, then new transactions are refused
+	for {
+		tr.mu.Lock()
+		draining := tr.draining
+		tr.mu.Unlock()
+		if draining {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if tr.begin() {
+		t.Fatal("expected new transaction to be refused while draining")
+	}
+
+	select {
+	case <-drained:
+		t.Fatal("expected drain to wait for active transaction")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	tr.end()
+	if err := <-drained; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDeliveryTracker_DrainTimeout(t *testing.T) {
+	tr := newDeliveryTracker()
+	tr.begin()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := tr.drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package smtp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alex/smtp-gotify/internal/config"
+	"github.com/alex/smtp-gotify/internal/ratelimit"
+	"github.com/emersion/go-smtp"
+)
+
+var errRateLimited = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+	Message:      "Rate limit exceeded, try again later",
+}
+
+// rateLimits throttles messages per client IP, authenticated user and
+// envelope sender. Nil limiters allow everything.
+type rateLimits struct {
+	ip     *ratelimit.Limiter
+	user   *ratelimit.Limiter
+	sender *ratelimit.Limiter
+}
+
+func newRateLimits(cfg config.SMTPConfig) (*rateLimits, error) {
+	var r rateLimits
+	var err error
+	if r.ip, err = ratelimit.Parse(cfg.RateLimitIP); err != nil {
+		return nil, fmt.Errorf("SMTP_RATE_LIMIT_IP: %w", err)
+	}
+	if r.user, err = ratelimit.Parse(cfg.RateLimitUser); err != nil {
+		return nil, fmt.Errorf("SMTP_RATE_LIMIT_USER: %w", err)
+	}
+	if r.sender, err = ratelimit.Parse(cfg.RateLimitSender); err != nil {
+		return nil, fmt.Errorf("SMTP_RATE_LIMIT_SENDER: %w", err)
+	}
+	return &r, nil
+}
+
+// allow charges one message to each applicable bucket and returns the name
+// of the first limit that was exceeded, or "" if the message may proceed.
+// A rejected message is refunded to the buckets it was already charged to.
+func (r *rateLimits) allow(ip, user, sender string) string {
+	if r == nil {
+		return ""
+	}
+	sender = strings.ToLower(sender)
+	if ip != "" && !r.ip.Allow(ip) {
+		return "ip"
+	}
+	if user != "" && !r.user.Allow(user) {
+		if ip != "" {
+			r.ip.Refund(ip)
+		}
+		return "user"
+	}
+	if !r.sender.Allow(sender) {
+		if ip != "" {
+			r.ip.Refund(ip)
+		}
+		if user != "" {
+			r.user.Refund(user)
+		}
+		return "sender"
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+package smtp
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+const tooManyConnections = "421 4.7.0 Too many connections, try again later\r\n"
+
+// connLimiter caps concurrent connections in total and per client IP. It is
+// shared by all listeners. Zero limits disable the respective cap.
+type connLimiter struct {
+	max      int
+	maxPerIP int
+	logger   *slog.Logger
+
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+func newConnLimiter(max, maxPerIP int, logger *slog.Logger) *connLimiter {
+	return &connLimiter{
+		max:      max,
+		maxPerIP: maxPerIP,
+		logger:   logger,
+		perIP:    make(map[string]int),
+	}
+}
+
+func (l *connLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.max > 0 && l.total >= l.max {
+		return false
+	}
+	if ip != "" && l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		return false
+	}
+
+	l.total++
+	if ip != "" {
+		l.perIP[ip]++
+	}
+	return true
+}
+
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if ip != "" {
+		l.perIP[ip]--
+		if l.perIP[ip] <= 0 {
+			delete(l.perIP, ip)
+		}
+	}
+}
+
+// limitListener rejects connections beyond the limiter's caps with a 421
+// greeting before go-smtp ever sees them. With silent set they are closed
+// without one, for implicit TLS clients that expect a handshake instead.
+type limitListener struct {
+	net.Listener
+	limiter *connLimiter
+	silent  bool
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		var ip string
+		if addr, ok := addrIP(c.RemoteAddr()); ok {
+			ip = addr.String()
+		}
+
+		if !l.limiter.acquire(ip) {
+			l.limiter.logger.Warn("connection limit reached", "remote", c.RemoteAddr())
+			if l.silent {
+				_ = c.Close()
+				continue
+			}
+			go reject(c)
+			continue
+		}
+
+		return &limitConn{Conn: c, release: func() { l.limiter.release(ip) }}, nil
+	}
+}
+
+func reject(c net.Conn) {
+	_ = c.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, _ = c.Write([]byte(tooManyConnections))
+	_ = c.Close()
+}
+
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
@@ -0,0 +1,115 @@
+package smtp
+
+import (
+	"bufio"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestLimitListener(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	l := &limitListener{Listener: raw, limiter: newConnLimiter(0, 1, slog.Default())}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	first, err := net.Dial("tcp", raw.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer first.Close()
+	serverSide := <-accepted
+
+	second, err := net.Dial("tcp", raw.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer second.Close()
+
+	line, err := bufio.NewReader(second).ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read rejection: %v", err)
+	}
+	if !strings.HasPrefix(line, "421 ") {
+		t.Errorf("expected 421 rejection, got %q", line)
+	}
+
+	// Closing the first connection frees the slot
+	serverSide.Close()
+	third, err := net.Dial("tcp", raw.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer third.Close()
+	if c := <-accepted; c == nil {
+		t.Error("expected connection to be accepted after release")
+	}
+}
+
+func TestLimitListener_Silent(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	l := &limitListener{Listener: raw, limiter: newConnLimiter(1, 0, slog.Default()), silent: true}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	first, err := net.Dial("tcp", raw.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer first.Close()
+	<-accepted
+
+	second, err := net.Dial("tcp", raw.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer second.Close()
+
+	// Closed without a plaintext greeting
+	if n, err := second.Read(make([]byte, 64)); n != 0 || err == nil {
+		t.Errorf("expected the connection to be closed without data, got %d bytes, %v", n, err)
+	}
+}
+
+func TestConnLimiter_Total(t *testing.T) {
+	l := newConnLimiter(2, 0, slog.Default())
+
+	if !l.acquire("192.0.2.1") || !l.acquire("192.0.2.2") {
+		t.Fatal("expected two connections to be allowed")
+	}
+	if l.acquire("192.0.2.3") {
+		t.Fatal("expected total cap to reject third connection")
+	}
+
+	l.release("192.0.2.1")
+	if !l.acquire("192.0.2.3") {
+		t.Error("expected connection after release")
+	}
+}
@@ -0,0 +1,51 @@
+package smtp
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// recipientPolicy decides which envelope recipients are accepted. A
+// recipient is accepted if its domain is listed or the address matches one
+// of the glob patterns. A nil policy accepts everything.
+type recipientPolicy struct {
+	domains  map[string]bool
+	patterns []string
+}
+
+func newRecipientPolicy(domains, patterns []string) (*recipientPolicy, error) {
+	if len(domains) == 0 && len(patterns) == 0 {
+		return nil, nil
+	}
+
+	p := &recipientPolicy{domains: make(map[string]bool, len(domains))}
+	for _, d := range domains {
+		p.domains[strings.ToLower(d)] = true
+	}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid recipient pattern %q: %w", pattern, err)
+		}
+		p.patterns = append(p.patterns, pattern)
+	}
+	return p, nil
+}
+
+func (p *recipientPolicy) accepts(addr string) bool {
+	if p == nil {
+		return true
+	}
+
+	addr = strings.ToLower(addr)
+	if _, domain, ok := strings.Cut(addr, "@"); ok && p.domains[domain] {
+		return true
+	}
+	for _, pattern := range p.patterns {
+		if ok, _ := path.Match(pattern, addr); ok {
+			return true
+		}
+	}
+	return false
+}
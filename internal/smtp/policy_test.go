@@ -0,0 +1,45 @@
+package smtp
+
+import "testing"
+
+func TestRecipientPolicy(t *testing.T) {
+	p, err := newRecipientPolicy([]string{"Example.com"}, []string{"alerts-*@home.lan", "root@*"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"anyone@example.com", true},
+		{"ANYONE@EXAMPLE.COM", true},
+		{"alerts-ups@home.lan", true},
+		{"root@nas.home.lan", true},
+		{"other@home.lan", false},
+		{"anyone@example.org", false},
+		{"no-domain", false},
+	}
+
+	for _, tt := range tests {
+		if got := p.accepts(tt.addr); got != tt.want {
+			t.Errorf("accepts(%q) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestRecipientPolicy_Empty(t *testing.T) {
+	p, err := newRecipientPolicy(nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !p.accepts("anyone@anywhere") {
+		t.Error("expected empty policy to accept everything")
+	}
+}
+
+func TestRecipientPolicy_InvalidPattern(t *testing.T) {
+	if _, err := newRecipientPolicy(nil, []string{"[invalid"}); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
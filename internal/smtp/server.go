@@ -1,42 +1,247 @@
 package smtp
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/alex/smtp-gotify/internal/auth"
 	"github.com/alex/smtp-gotify/internal/config"
 	"github.com/alex/smtp-gotify/internal/mail"
+	"github.com/alex/smtp-gotify/internal/proxyproto"
 	"github.com/emersion/go-smtp"
 )
 
+// abortWait bounds how long Shutdown waits for cancelled deliveries to
+// return once the grace period has expired.
+const abortWait = time.Second
+
 type Server struct {
+	listeners    []*listener
+	backend      *Backend
+	conns        *connLimiter
+	proxyTrusted []netip.Prefix
+	logger       *slog.Logger
+
+	// cancel aborts the root context all sessions derive from
+	cancel  context.CancelFunc
+	closing atomic.Bool
+	mu      sync.Mutex
+	bound   []net.Listener
+}
+
+type listener struct {
+	cfg    config.ListenerConfig
 	server *smtp.Server
-	logger *slog.Logger
 }
 
-func NewServer(cfg config.SMTPConfig, logger *slog.Logger, parser *mail.Parser, forwarder Forwarder) *Server {
+func NewServer(cfg config.SMTPConfig, logger *slog.Logger, parser *mail.Parser, forwarder Forwarder) (*Server, error) {
+	authenticator, err := auth.Load(cfg.AuthFile, cfg.AuthUsers)
+	if err != nil {
+		return nil, fmt.Errorf("load SMTP users: %w", err)
+	}
+
+	recipients, err := newRecipientPolicy(cfg.AcceptDomains, cfg.AcceptAddresses)
+	if err != nil {
+		return nil, err
+	}
+
+	access, err := newAccessList(cfg.AllowNetworks, cfg.DenyNetworks, cfg.TrustedNetworks)
+	if err != nil {
+		return nil, err
+	}
+
+	limits, err := newRateLimits(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if limits.ip != nil || limits.user != nil || limits.sender != nil {
+		logger.Info("rate limits enabled", "ip", limits.ip.String(), "user", limits.user.String(), "sender", limits.sender.String())
+	}
+
 	backend := NewBackend(logger, parser, forwarder)
+	backend.auth = authenticator
+	backend.recipients = recipients
+	backend.access = access
+	backend.limits = limits
+	backend.dataTimeout = cfg.DataTimeout
+	backend.deliveryTimeout = cfg.DeliveryTimeout
+
+	var tlsConfig *tls.Config
+	if cfg.TLSCert != "" {
+		tlsConfig, err = newTLSConfig(cfg, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	proxyTrusted, err := parsePrefixes(cfg.ProxyTrusted)
+	if err != nil {
+		return nil, fmt.Errorf("SMTP_PROXY_TRUSTED: %w", err)
+	}
+
+	banner := cfg.Banner
+	if banner == "" {
+		banner = cfg.Domain
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	backend.ctx = ctx
 
-	s := smtp.NewServer(backend)
-	s.Addr = cfg.Listen
-	s.Domain = cfg.Domain
-	s.MaxMessageBytes = int64(cfg.MaxSize)
-	s.ReadTimeout = 60 * time.Second
-	s.WriteTimeout = 60 * time.Second
-	s.AllowInsecureAuth = true
+	srv := &Server{
+		backend:      backend,
+		cancel:       cancel,
+		conns:        newConnLimiter(cfg.MaxConnections, cfg.MaxConnsPerIP, logger),
+		proxyTrusted: proxyTrusted,
+		logger:       logger,
+	}
+	for _, l := range cfg.Listeners {
+		s := smtp.NewServer(backend.forListener(l))
+		s.Network = l.Network
+		s.Addr = l.Addr
+		s.LMTP = l.Protocol == "lmtp"
+		// go-smtp only uses Domain for the 220 greeting
+		s.Domain = banner
+		s.MaxMessageBytes = int64(cfg.MaxSize)
+		s.MaxRecipients = cfg.MaxRecipients
+		s.MaxLineLength = cfg.MaxLineLength
+		s.ReadTimeout = cfg.ReadTimeout
+		s.WriteTimeout = cfg.WriteTimeout
+		s.AllowInsecureAuth = !l.TLSRequired
+		if l.TLS != "none" {
+			s.TLSConfig = tlsConfig
+		}
 
-	return &Server{
-		server: s,
-		logger: logger,
+		srv.listeners = append(srv.listeners, &listener{cfg: l, server: s})
 	}
+
+	return srv, nil
 }
 
+// ListenAndServe binds all listeners and serves them until one fails or the
+// server is closed.
 func (s *Server) ListenAndServe() error {
-	s.logger.Info("starting SMTP server", "addr", s.server.Addr, "domain", s.server.Domain)
-	return s.server.ListenAndServe()
+	netListeners := make([]net.Listener, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		nl, err := s.listen(l)
+		if err != nil {
+			for _, opened := range netListeners {
+				opened.Close()
+			}
+			return fmt.Errorf("listener %s: %w", l.cfg.Name, err)
+		}
+		netListeners = append(netListeners, nl)
+	}
+
+	s.mu.Lock()
+	s.bound = netListeners
+	s.mu.Unlock()
+
+	errCh := make(chan error, len(s.listeners))
+	for i, l := range s.listeners {
+		s.logger.Info("starting SMTP server",
+			"listener", l.cfg.Name,
+			"network", l.cfg.Network,
+			"addr", l.cfg.Addr,
+			"protocol", l.cfg.Protocol,
+			"tls", l.cfg.TLS,
+			"proxy_protocol", l.cfg.ProxyProtocol,
+			"banner", l.server.Domain,
+		)
+		go func(l *listener, nl net.Listener) {
+			// Closed listeners during Shutdown are expected
+			if err := l.server.Serve(nl); err != nil && !s.closing.Load() {
+				errCh <- fmt.Errorf("listener %s: %w", l.cfg.Name, err)
+				return
+			}
+			errCh <- nil
+		}(l, netListeners[i])
+	}
+
+	return <-errCh
+}
+
+// Shutdown stops accepting connections, waits for DATA transactions in
+// progress to finish until ctx is done, then cancels any remaining
+// deliveries and closes all connections.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.logger.Info("stopping SMTP server")
+	s.closing.Store(true)
+
+	s.mu.Lock()
+	for _, nl := range s.bound {
+		nl.Close()
+	}
+	s.mu.Unlock()
+
+	err := s.backend.deliveries.drain(ctx)
+	s.cancel()
+	if err != nil {
+		s.logger.Warn("shutdown grace period expired, cancelling in-flight deliveries")
+		// Give cancelled deliveries a moment to answer their clients
+		abortCtx, abortCancel := context.WithTimeout(context.Background(), abortWait)
+		s.backend.deliveries.drain(abortCtx)
+		abortCancel()
+	}
+
+	return errors.Join(err, s.closeAll())
 }
 
+// Close immediately closes all listeners and connections.
 func (s *Server) Close() error {
 	s.logger.Info("stopping SMTP server")
-	return s.server.Close()
+	s.closing.Store(true)
+	s.cancel()
+	return s.closeAll()
+}
+
+func (s *Server) closeAll() error {
+	var errs []error
+	for _, l := range s.listeners {
+		if err := l.server.Close(); err != nil && !errors.Is(err, smtp.ErrServerClosed) {
+			errs = append(errs, fmt.Errorf("listener %s: %w", l.cfg.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Server) listen(l *listener) (net.Listener, error) {
+	if l.cfg.Network == "unix" {
+		// Remove a stale socket left behind by a previous run
+		if info, err := os.Stat(l.cfg.Addr); err == nil && info.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(l.cfg.Addr)
+		}
+	}
+
+	nl, err := net.Listen(l.cfg.Network, l.cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolve the real client address before connection caps are applied
+	if l.cfg.ProxyProtocol {
+		nl = &proxyproto.Listener{
+			Listener: nl,
+			Trusted:  s.proxyTrusted,
+			Logger:   s.logger,
+		}
+	}
+
+	// The limiter stays below TLS, so go-smtp still sees a *tls.Conn. A
+	// plaintext 421 would only break the client's handshake, so over-limit
+	// implicit TLS connections are closed without one.
+	nl = &limitListener{Listener: nl, limiter: s.conns, silent: l.cfg.TLS == "implicit"}
+
+	if l.cfg.TLS == "implicit" {
+		nl = tls.NewListener(nl, l.server.TLSConfig)
+	}
+	return nl, nil
 }
@@ -0,0 +1,179 @@
+package smtp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alex/smtp-gotify/internal/config"
+	"github.com/alex/smtp-gotify/internal/mail"
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+)
+
+type chanForwarder chan *mail.Message
+
+func (c chanForwarder) Forward(ctx context.Context, msg *mail.Message) error {
+	c <- msg
+	return nil
+}
+
+func dialUnix(t *testing.T, path string) *smtp.Client {
+	t.Helper()
+	var lastErr error
+	for i := 0; i < 50; i++ {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			return smtp.NewClient(conn)
+		}
+		lastErr = err
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("failed to connect to %s: %v", path, lastErr)
+	return nil
+}
+
+func TestServer_MultipleListeners(t *testing.T) {
+	dir := t.TempDir()
+	open := filepath.Join(dir, "open.sock")
+	authed := filepath.Join(dir, "auth.sock")
+
+	forwarder := make(chanForwarder, 1)
+	server, err := NewServer(config.SMTPConfig{
+		Domain:    "localhost",
+		MaxSize:   1024 * 1024,
+		AuthUsers: []string{"printer:plain:secret"},
+		Listeners: []config.ListenerConfig{
+			{Name: "open", Network: "unix", Addr: open, TLS: "none"},
+			{Name: "auth", Network: "unix", Addr: authed, TLS: "none", AuthRequired: true},
+		},
+	}, slog.Default(), mail.NewParser(), forwarder)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- server.ListenAndServe() }()
+
+	email := "Subject: Hello\r\n\r\nBody\r\n"
+
+	c := dialUnix(t, open)
+	if err := c.SendMail("a@example.com", []string{"b@example.com"}, strings.NewReader(email)); err != nil {
+		t.Fatalf("unexpected error on open listener: %v", err)
+	}
+	if msg := <-forwarder; msg.Subject != "Hello" {
+		t.Errorf("expected subject Hello, got %s", msg.Subject)
+	}
+	c.Close()
+
+	c = dialUnix(t, authed)
+	err = c.SendMail("a@example.com", []string{"b@example.com"}, strings.NewReader(email))
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 530 {
+		t.Fatalf("expected 530 without AUTH, got %v", err)
+	}
+	c.Close()
+
+	c = dialUnix(t, authed)
+	if err := c.Auth(sasl.NewPlainClient("", "printer", "secret")); err != nil {
+		t.Fatalf("unexpected auth error: %v", err)
+	}
+	if err := c.SendMail("a@example.com", []string{"b@example.com"}, strings.NewReader(email)); err != nil {
+		t.Fatalf("unexpected error after AUTH: %v", err)
+	}
+	if msg := <-forwarder; msg.User != "printer" {
+		t.Errorf("expected user printer, got %s", msg.User)
+	}
+	c.Close()
+
+	if err := server.Close(); err != nil {
+		t.Errorf("unexpected close error: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("unexpected serve error: %v", err)
+	}
+}
+
+func TestServer_TrustedNetworksSkipUnixSockets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smtp.sock")
+
+	forwarder := make(chanForwarder, 1)
+	server, err := NewServer(config.SMTPConfig{
+		Domain:          "localhost",
+		MaxSize:         1024 * 1024,
+		TrustedNetworks: []string{"192.0.2.0/24"},
+		Listeners:       []config.ListenerConfig{{Name: "local", Network: "unix", Addr: path, TLS: "none"}},
+	}, slog.Default(), mail.NewParser(), forwarder)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- server.ListenAndServe() }()
+
+	c := dialUnix(t, path)
+	if err := c.SendMail("a@example.com", []string{"b@example.com"}, strings.NewReader("Subject: Hi\r\n\r\nBody\r\n")); err != nil {
+		t.Fatalf("expected the socket to accept mail without AUTH, got %v", err)
+	}
+	<-forwarder
+	c.Close()
+
+	if err := server.Close(); err != nil {
+		t.Errorf("unexpected close error: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("unexpected serve error: %v", err)
+	}
+}
+
+type blockingForwarder struct {
+	started chan struct{}
+}
+
+func (f blockingForwarder) Forward(ctx context.Context, msg *mail.Message) error {
+	close(f.started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestServer_ShutdownCancelsDelivery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smtp.sock")
+
+	forwarder := blockingForwarder{started: make(chan struct{})}
+	server, err := NewServer(config.SMTPConfig{
+		Domain:    "localhost",
+		MaxSize:   1024 * 1024,
+		Listeners: []config.ListenerConfig{{Name: "default", Network: "unix", Addr: path, TLS: "none"}},
+	}, slog.Default(), mail.NewParser(), forwarder)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- server.ListenAndServe() }()
+
+	c := dialUnix(t, path)
+	defer c.Close()
+	sent := make(chan error, 1)
+	go func() {
+		sent <- c.SendMail("a@example.com", []string{"b@example.com"}, strings.NewReader("Subject: Hi\r\n\r\nBody\r\n"))
+	}()
+	<-forwarder.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected grace period to expire, got %v", err)
+	}
+	if err := <-sent; err == nil {
+		t.Error("expected cancelled delivery to fail")
+	}
+	if err := <-done; err != nil {
+		t.Errorf("unexpected serve error: %v", err)
+	}
+}
@@ -2,51 +2,232 @@ package smtp
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log/slog"
+	"net"
+	"time"
 
+	"github.com/alex/smtp-gotify/internal/auth"
+	"github.com/alex/smtp-gotify/internal/delivery"
 	"github.com/alex/smtp-gotify/internal/mail"
+	"github.com/emersion/go-sasl"
 	"github.com/emersion/go-smtp"
 )
 
+var errAuthRequired = &smtp.SMTPError{
+	Code:         530,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 0},
+	Message:      "Authentication required",
+}
+
+var errTLSRequired = &smtp.SMTPError{
+	Code:         530,
+	EnhancedCode: smtp.EnhancedCode{5, 7, 0},
+	Message:      "Must issue a STARTTLS command first",
+}
+
+var errRecipientRejected = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+	Message:      "Recipient address rejected",
+}
+
+var errShuttingDown = &smtp.SMTPError{
+	Code:         421,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 2},
+	Message:      "Service shutting down, try again later",
+}
+
+var errDeliveryAborted = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 4, 1},
+	Message:      "Delivery timed out, try again later",
+}
+
+var errPartialDelivery = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+	Message:      "Message partially delivered, try again later",
+}
+
+var errDeliveryFailed = &smtp.SMTPError{
+	Code:         451,
+	EnhancedCode: smtp.EnhancedCode{4, 4, 0},
+	Message:      "Delivery failed temporarily, try again later",
+}
+
+var errDeliveryRejected = &smtp.SMTPError{
+	Code:         554,
+	EnhancedCode: smtp.EnhancedCode{5, 0, 0},
+	Message:      "Delivery rejected by notification server",
+}
+
 type Session struct {
-	logger    *slog.Logger
-	parser    *mail.Parser
-	forwarder Forwarder
-	from      string
-	to        []string
+	ctx             context.Context
+	cancel          context.CancelFunc
+	logger          *slog.Logger
+	parser          *mail.Parser
+	forwarder       Forwarder
+	auth            auth.Authenticator
+	recipients      *recipientPolicy
+	limits          *rateLimits
+	authRequired    bool
+	tlsRequired     bool
+	tls             bool
+	trusted         bool
+	remoteIP        string
+	conn            net.Conn
+	dataTimeout     time.Duration
+	deliveryTimeout time.Duration
+	deliveries      *deliveryTracker
+	user            string
+	from            string
+	to              []string
 }
 
 func NewSession(logger *slog.Logger, parser *mail.Parser, forwarder Forwarder) *Session {
 	return &Session{
+		ctx:       context.Background(),
 		logger:    logger,
 		parser:    parser,
 		forwarder: forwarder,
 	}
 }
 
-func (s *Session) AuthPlain(username, password string) error {
-	// Accept any authentication
-	return nil
+func (s *Session) AuthMechanisms() []string {
+	if s.auth == nil {
+		return nil
+	}
+	return []string{sasl.Plain}
+}
+
+func (s *Session) Auth(mech string) (sasl.Server, error) {
+	if s.auth == nil || mech != sasl.Plain {
+		return nil, smtp.ErrAuthUnknownMechanism
+	}
+
+	return sasl.NewPlainServer(func(identity, username, password string) error {
+		if identity != "" && identity != username {
+			s.logger.Warn("authentication failed", "username", username, "identity", identity)
+			return smtp.ErrAuthFailed
+		}
+		if err := s.auth.Authenticate(username, password); err != nil {
+			s.logger.Warn("authentication failed", "username", username, "error", err)
+			return smtp.ErrAuthFailed
+		}
+		s.user = username
+		s.logger.Debug("authenticated", "username", username)
+		return nil
+	}), nil
 }
 
 func (s *Session) Mail(from string, opts *smtp.MailOptions) error {
+	if s.tlsRequired && !s.tls {
+		return errTLSRequired
+	}
+	if s.authRequired && s.user == "" && !s.trusted {
+		return errAuthRequired
+	}
+	if limit := s.limits.allow(s.remoteIP, s.user, from); limit != "" {
+		s.logger.Warn("rate limit exceeded",
+			"limit", limit,
+			"remote", s.remoteIP,
+			"user", s.user,
+			"from", from,
+		)
+		return errRateLimited
+	}
 	s.from = from
 	s.logger.Debug("mail from", "from", from)
 	return nil
 }
 
 func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
+	if !s.recipients.accepts(to) {
+		s.logger.Info("recipient rejected", "to", to, "from", s.from)
+		return errRecipientRejected
+	}
 	s.to = append(s.to, to)
 	s.logger.Debug("rcpt to", "to", to)
 	return nil
 }
 
 func (s *Session) Data(r io.Reader) error {
+	if !s.deliveries.begin() {
+		return errShuttingDown
+	}
+	defer s.deliveries.end()
+
+	msg, err := s.parse(r)
+	if err != nil {
+		return err
+	}
+	return s.forward(msg)
+}
+
+// LMTPData delivers the message once per route its recipients resolve to,
+// so recipients sharing a route get a single notification and a failure on
+// one route does not affect the status reported for the others.
+func (s *Session) LMTPData(r io.Reader, status smtp.StatusCollector) error {
+	if !s.deliveries.begin() {
+		return errShuttingDown
+	}
+	defer s.deliveries.end()
+
+	msg, err := s.parse(r)
+	if err != nil {
+		return err
+	}
+
+	var routes []string
+	groups := make(map[string][]string)
+	for _, rcpt := range s.to {
+		route := s.match(msg, rcpt)
+		if _, ok := groups[route]; !ok {
+			routes = append(routes, route)
+		}
+		groups[route] = append(groups[route], rcpt)
+	}
+
+	for _, route := range routes {
+		routeMsg := *msg
+		routeMsg.Recipients = groups[route]
+		// Each route is its own notification and tracked separately
+		if route != "" {
+			routeMsg.ID = msg.ID + " " + route
+		}
+		err := s.forward(&routeMsg)
+		for _, rcpt := range groups[route] {
+			status.SetStatus(rcpt, err)
+		}
+	}
+	return nil
+}
+
+// match returns the route msg takes for rcpt alone, or "" if the forwarder
+// cannot tell.
+func (s *Session) match(msg *mail.Message, rcpt string) string {
+	m, ok := s.forwarder.(routeMatcher)
+	if !ok {
+		return ""
+	}
+	rcptMsg := *msg
+	rcptMsg.Recipients = []string{rcpt}
+	return m.Match(&rcptMsg)
+}
+
+func (s *Session) parse(r io.Reader) (*mail.Message, error) {
+	// go-smtp only refreshes the read deadline per command line, so give
+	// the whole message body its own deadline
+	if s.conn != nil && s.dataTimeout > 0 {
+		_ = s.conn.SetReadDeadline(time.Now().Add(s.dataTimeout))
+	}
+
 	msg, err := s.parser.Parse(r)
 	if err != nil {
 		s.logger.Error("failed to parse email", "error", err)
-		return err
+		return nil, err
 	}
 
 	// Use envelope addresses if headers are empty
@@ -56,21 +237,47 @@ func (s *Session) Data(r io.Reader) error {
 	if len(msg.To) == 0 {
 		msg.To = s.to
 	}
+	msg.Recipients = s.to
+	msg.User = s.user
+	msg.RemoteAddr = s.remoteIP
 
 	s.logger.Info("received email",
 		"from", msg.From,
 		"to", msg.To,
 		"subject", msg.Subject,
+		"user", s.user,
+		"remote", s.remoteIP,
 		"attachments", len(msg.Attachments),
 	)
 
-	ctx := context.Background()
+	return msg, nil
+}
+
+func (s *Session) forward(msg *mail.Message) error {
+	ctx := s.ctx
+	if s.deliveryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.deliveryTimeout)
+		defer cancel()
+	}
+
 	if err := s.forwarder.Forward(ctx, msg); err != nil {
-		s.logger.Error("failed to forward to gotify", "error", err)
-		return err
+		s.logger.Error("failed to forward to gotify", "recipients", msg.Recipients, "error", err)
+		if ctx.Err() != nil {
+			return errDeliveryAborted
+		}
+		// A retry only reaches the destinations that failed
+		var partial interface{ Partial() bool }
+		if errors.As(err, &partial) && partial.Partial() {
+			return errPartialDelivery
+		}
+		if delivery.Permanent(err) {
+			return errDeliveryRejected
+		}
+		return errDeliveryFailed
 	}
 
-	s.logger.Info("forwarded to gotify", "subject", msg.Subject)
+	s.logger.Info("forwarded to gotify", "subject", msg.Subject, "recipients", msg.Recipients)
 	return nil
 }
 
@@ -80,5 +287,8 @@ func (s *Session) Reset() {
 }
 
 func (s *Session) Logout() error {
+	if s.cancel != nil {
+		s.cancel()
+	}
 	return nil
 }
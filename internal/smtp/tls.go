@@ -0,0 +1,33 @@
+package smtp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+
+	"github.com/alex/smtp-gotify/internal/config"
+	"github.com/alex/smtp-gotify/internal/tlsutil"
+)
+
+func newTLSConfig(cfg config.SMTPConfig, logger *slog.Logger) (*tls.Config, error) {
+	reloader, err := tlsutil.NewReloader(cfg.TLSCert, cfg.TLSKey, logger)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if cfg.TLSClientCA != "" {
+		pool, err := tlsutil.LoadCertPool(cfg.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("load TLS client CA: %w", err)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,125 @@
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("dead letter not found")
+
+// DeadLetters gives access to messages that failed permanently or ran out of
+// attempts. It works on the spool directory alone, so the CLI can use it
+// while the server is running.
+type DeadLetters struct {
+	dir      string
+	replayed func()
+}
+
+// OpenDeadLetters opens the dead-letter queue of the spool in dir.
+func OpenDeadLetters(dir string) (*DeadLetters, error) {
+	for _, sub := range []string{queueDir, tmpDir, deadLetterDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create spool directory: %w", err)
+		}
+	}
+	return &DeadLetters{dir: dir}, nil
+}
+
+// List returns all dead letters, oldest first.
+func (d *DeadLetters) List() ([]*Entry, error) {
+	files, err := os.ReadDir(filepath.Join(d.dir, deadLetterDir))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		e, err := readEntry(filepath.Join(d.dir, deadLetterDir, f.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name(), err)
+		}
+		entries = append(entries, e)
+	}
+
+	slices.SortFunc(entries, func(a, b *Entry) int { return a.Failed.Compare(b.Failed) })
+	return entries, nil
+}
+
+func (d *DeadLetters) Get(id string) (*Entry, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+	e, err := readEntry(entryPath(d.dir, deadLetterDir, id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return e, err
+}
+
+// Replay puts a dead letter back into the delivery queue with a fresh
+// attempt count. A non-empty route overrides the route the message would
+// normally be delivered to.
+func (d *DeadLetters) Replay(id, route string) error {
+	e, err := d.Get(id)
+	if err != nil {
+		return err
+	}
+
+	e.Attempts = 0
+	e.NextAttempt = time.Now()
+	e.LastError = ""
+	e.Failed = time.Time{}
+	if route != "" {
+		e.Message.Route = route
+	}
+
+	if err := writeEntry(d.dir, queueDir, e); err != nil {
+		return err
+	}
+	if err := os.Remove(entryPath(d.dir, deadLetterDir, id)); err != nil {
+		return err
+	}
+
+	if d.replayed != nil {
+		d.replayed()
+	}
+	return nil
+}
+
+func (d *DeadLetters) Purge(id string) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+	err := os.Remove(entryPath(d.dir, deadLetterDir, id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// PurgeAll deletes every dead letter and returns how many were removed.
+func (d *DeadLetters) PurgeAll() (int, error) {
+	entries, err := d.List()
+	if err != nil {
+		return 0, err
+	}
+	for i, e := range entries {
+		if err := d.Purge(e.ID); err != nil && !errors.Is(err, ErrNotFound) {
+			return i, err
+		}
+	}
+	return len(entries), nil
+}
+
+// validID rejects IDs that would escape the dead-letter directory.
+func validID(id string) bool {
+	return id != "" && !strings.HasPrefix(id, ".") && !strings.ContainsAny(id, `/\`)
+}
@@ -0,0 +1,135 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alex/smtp-gotify/internal/gotify"
+	"github.com/alex/smtp-gotify/internal/mail"
+	"github.com/alex/smtp-gotify/internal/template"
+)
+
+type permanentError struct{}
+
+func (permanentError) Error() string   { return "invalid token" }
+func (permanentError) Temporary() bool { return false }
+
+type failForwarder struct {
+	err   error
+	calls int
+}
+
+func (f *failForwarder) Forward(ctx context.Context, msg *mail.Message) error {
+	f.calls++
+	return f.err
+}
+
+func TestSpool_PermanentFailure(t *testing.T) {
+	dir := t.TempDir()
+	fwd := &failForwarder{err: fmt.Errorf("send: %w", permanentError{})}
+	s := newTestSpool(t, dir, fwd)
+
+	if err := s.Forward(context.Background(), &mail.Message{Subject: "Bad token"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, e := range s.due(time.Now()) {
+		s.deliver(context.Background(), e)
+	}
+
+	if fwd.calls != 1 {
+		t.Errorf("expected a single attempt, got %d", fwd.calls)
+	}
+	if s.Len() != 0 {
+		t.Error("expected message to leave the queue")
+	}
+
+	dead, err := s.DeadLetters().List()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dead) != 1 || dead[0].LastError != "send: invalid token" || dead[0].Failed.IsZero() {
+		t.Fatalf("unexpected dead letters: %+v", dead)
+	}
+}
+
+func TestDeadLetters_ReplayAndPurge(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestSpool(t, dir, &failForwarder{err: permanentError{}})
+
+	for _, subject := range []string{"first", "second"} {
+		if err := s.Forward(context.Background(), &mail.Message{Subject: subject}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	for _, e := range s.due(time.Now()) {
+		s.deliver(context.Background(), e)
+	}
+
+	d := s.DeadLetters()
+	dead, err := d.List()
+	if err != nil || len(dead) != 2 {
+		t.Fatalf("expected 2 dead letters, got %d (%v)", len(dead), err)
+	}
+
+	if err := d.Replay(dead[0].ID, "alerts"); err != nil {
+		t.Fatalf("unexpected replay error: %v", err)
+	}
+	if err := s.scan(); err != nil {
+		t.Fatalf("unexpected scan error: %v", err)
+	}
+	due := s.due(time.Now())
+	if len(due) != 1 || due[0].Message.Route != "alerts" || due[0].Attempts != 0 || due[0].LastError != "" {
+		t.Fatalf("expected replayed message queued for route alerts, got %+v", due)
+	}
+
+	if _, err := d.Get(dead[0].ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected replayed message to leave the dead-letter queue, got %v", err)
+	}
+	if err := d.Purge("../queue/" + due[0].ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected path traversal to be rejected, got %v", err)
+	}
+
+	n, err := d.PurgeAll()
+	if err != nil || n != 1 {
+		t.Fatalf("expected to purge 1 dead letter, got %d (%v)", n, err)
+	}
+	if dead, _ := d.List(); len(dead) != 0 {
+		t.Errorf("expected empty dead-letter queue, got %d", len(dead))
+	}
+}
+
+func TestSpool_GotifyUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	renderer, _ := template.NewRenderer("{{.Subject}}", "{{.Body}}")
+	client := gotify.NewClient(gotify.Config{
+		URL:      server.URL,
+		Tokens:   []string{"token"},
+		Renderer: renderer,
+		Logger:   slog.Default(),
+	})
+
+	dir := t.TempDir()
+	s := newTestSpool(t, dir, client)
+
+	if err := s.Forward(context.Background(), &mail.Message{Subject: "Outage"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, e := range s.due(time.Now()) {
+		s.deliver(context.Background(), e)
+	}
+
+	if s.Len() != 1 || queued(t, dir) != 1 {
+		t.Error("expected message to stay queued while Gotify is down")
+	}
+	if dead, _ := s.DeadLetters().List(); len(dead) != 0 {
+		t.Errorf("expected no dead letters, got %d", len(dead))
+	}
+}
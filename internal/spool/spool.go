@@ -0,0 +1,440 @@
+package spool
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alex/smtp-gotify/internal/delivery"
+	"github.com/alex/smtp-gotify/internal/mail"
+)
+
+const (
+	queueDir      = "queue"
+	tmpDir        = "tmp"
+	deadLetterDir = "deadletter"
+
+	// scanInterval bounds how long a message replayed by another process
+	// waits in the queue directory before the worker picks it up.
+	scanInterval = 10 * time.Second
+)
+
+type Forwarder interface {
+	Forward(ctx context.Context, msg *mail.Message) error
+}
+
+type Config struct {
+	Dir         string
+	Forwarder   Forwarder
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	MaxAttempts int           // 0 retries forever
+	Timeout     time.Duration // per delivery attempt, 0 = none
+	Workers     int           // concurrent deliveries, 0 = 4
+	Logger      *slog.Logger
+}
+
+// Entry is the on-disk representation of a queued or dead-lettered message.
+type Entry struct {
+	ID          string        `json:"id"`
+	Message     *mail.Message `json:"message"`
+	Queued      time.Time     `json:"queued"`
+	Attempts    int           `json:"attempts"`
+	NextAttempt time.Time     `json:"next_attempt"`
+	LastError   string        `json:"last_error,omitempty"`
+	// Failed is set when the message was moved to the dead-letter queue.
+	Failed time.Time `json:"failed,omitzero"`
+}
+
+// Spool persists messages to disk and delivers them in the background.
+// It implements the same Forwarder interface as the Gotify client, so the
+// SMTP server can hand messages to it and answer 250 right away.
+type Spool struct {
+	cfg    Config
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	entries map[string]*Entry
+	// busy holds the IDs of entries a worker is delivering
+	busy map[string]bool
+	wake chan struct{}
+}
+
+// New opens the spool directory, creating it if needed, and loads messages
+// left over from a previous run.
+func New(cfg Config) (*Spool, error) {
+	for _, dir := range []string{queueDir, tmpDir, deadLetterDir} {
+		if err := os.MkdirAll(filepath.Join(cfg.Dir, dir), 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create spool directory: %w", err)
+		}
+	}
+
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+
+	s := &Spool{
+		cfg:     cfg,
+		logger:  cfg.Logger,
+		entries: make(map[string]*Entry),
+		busy:    make(map[string]bool),
+		wake:    make(chan struct{}, 1),
+	}
+
+	// Partially written files never made it into the queue
+	tmp, err := os.ReadDir(filepath.Join(cfg.Dir, tmpDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+	for _, f := range tmp {
+		os.Remove(filepath.Join(cfg.Dir, tmpDir, f.Name()))
+	}
+
+	if err := s.scan(); err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+	if n := s.Len(); n > 0 {
+		s.logger.Info("loaded spooled messages", "count", n)
+	}
+
+	return s, nil
+}
+
+// Forward writes msg to the spool and wakes the delivery worker. The message
+// is on disk when Forward returns nil.
+func (s *Spool) Forward(ctx context.Context, msg *mail.Message) error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	e := &Entry{
+		ID:          id,
+		Message:     msg,
+		Queued:      now,
+		NextAttempt: now,
+	}
+
+	// Hold the lock so scan cannot load the file before it is indexed
+	s.mu.Lock()
+	err = writeEntry(s.cfg.Dir, queueDir, e)
+	if err == nil {
+		s.entries[id] = e
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to spool message: %w", err)
+	}
+
+	s.logger.Info("spooled message", "id", id, "subject", msg.Subject)
+	s.notify()
+	return nil
+}
+
+// Match returns the route the forwarder behind the spool resolves for msg,
+// or "" if it cannot tell.
+func (s *Spool) Match(msg *mail.Message) string {
+	if m, ok := s.cfg.Forwarder.(interface{ Match(*mail.Message) string }); ok {
+		return m.Match(msg)
+	}
+	return ""
+}
+
+// DeadLetters returns the dead-letter queue of this spool. Replayed messages
+// are picked up by the worker right away.
+func (s *Spool) DeadLetters() *DeadLetters {
+	return &DeadLetters{dir: s.cfg.Dir, replayed: s.notify}
+}
+
+// Len returns the number of messages waiting for delivery.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Run delivers spooled messages with up to Workers deliveries in flight
+// until ctx is cancelled, and waits for running deliveries to return.
+func (s *Spool) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	workers := make(chan struct{}, s.cfg.Workers)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-s.wake:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+
+		// Pick up messages replayed by another process
+		if err := s.scan(); err != nil {
+			s.logger.Error("failed to scan spool directory", "error", err)
+		}
+
+		for _, e := range s.due(time.Now()) {
+			select {
+			case workers <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			s.setBusy(e.ID, true)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.deliver(ctx, e)
+				s.setBusy(e.ID, false)
+				<-workers
+				// The entry may be due again sooner than the timer fires
+				s.notify()
+			}()
+		}
+
+		timer.Reset(s.untilNext(time.Now()))
+	}
+}
+
+func (s *Spool) deliver(ctx context.Context, e *Entry) {
+	attemptCtx := ctx
+	if s.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		attemptCtx, cancel = context.WithTimeout(ctx, s.cfg.Timeout)
+		defer cancel()
+	}
+
+	err := s.cfg.Forwarder.Forward(attemptCtx, e.Message)
+	if err == nil {
+		s.remove(e)
+		s.logger.Info("delivered spooled message", "id", e.ID, "attempts", e.Attempts+1)
+		return
+	}
+
+	// Shutting down is not the message's fault
+	if ctx.Err() != nil {
+		return
+	}
+
+	e.Attempts++
+	e.LastError = err.Error()
+
+	if delivery.Permanent(err) || (s.cfg.MaxAttempts > 0 && e.Attempts >= s.cfg.MaxAttempts) {
+		s.bury(e)
+		return
+	}
+
+	e.NextAttempt = time.Now().Add(s.backoff(e.Attempts))
+	if werr := writeEntry(s.cfg.Dir, queueDir, e); werr != nil {
+		s.logger.Error("failed to update spool file", "id", e.ID, "error", werr)
+	}
+	s.logger.Warn("spooled delivery failed", "id", e.ID, "attempts", e.Attempts, "retry_at", e.NextAttempt, "error", err)
+}
+
+// backoff doubles the delay for every failed attempt, capped at MaxBackoff.
+func (s *Spool) backoff(attempts int) time.Duration {
+	d := s.cfg.MinBackoff
+	for i := 1; i < attempts && d < s.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, s.cfg.MaxBackoff)
+}
+
+func (s *Spool) setBusy(id string, busy bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if busy {
+		s.busy[id] = true
+	} else {
+		delete(s.busy, id)
+	}
+}
+
+// due returns the entries ready for delivery that no worker is busy with.
+func (s *Spool) due(now time.Time) []*Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*Entry
+	for _, e := range s.entries {
+		if !s.busy[e.ID] && !e.NextAttempt.After(now) {
+			due = append(due, e)
+		}
+	}
+	return due
+}
+
+func (s *Spool) untilNext(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := time.Duration(-1)
+	for _, e := range s.entries {
+		if s.busy[e.ID] {
+			continue
+		}
+		d := max(e.NextAttempt.Sub(now), 0)
+		if next < 0 || d < next {
+			next = d
+		}
+	}
+	if next < 0 || next > scanInterval {
+		return scanInterval
+	}
+	return next
+}
+
+// scan indexes queue files that are not known yet.
+func (s *Spool) scan() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := os.ReadDir(filepath.Join(s.cfg.Dir, queueDir))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		id, ok := strings.CutSuffix(f.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if _, known := s.entries[id]; known {
+			continue
+		}
+
+		path := filepath.Join(s.cfg.Dir, queueDir, f.Name())
+		e, err := readEntry(path)
+		if err != nil {
+			// Move it out of the way so it is not retried on every scan
+			s.logger.Error("skipping unreadable spool file", "file", path, "error", err)
+			os.Rename(path, path+".bad")
+			continue
+		}
+		s.entries[e.ID] = e
+	}
+	return nil
+}
+
+// bury moves e to the dead-letter queue.
+func (s *Spool) bury(e *Entry) {
+	e.Failed = time.Now()
+	if err := writeEntry(s.cfg.Dir, deadLetterDir, e); err != nil {
+		// Keep it queued rather than lose it
+		s.logger.Error("failed to write dead letter", "id", e.ID, "error", err)
+		e.NextAttempt = time.Now().Add(s.cfg.MaxBackoff)
+		return
+	}
+	s.remove(e)
+	s.logger.Error("moved message to dead-letter queue", "id", e.ID, "attempts", e.Attempts, "reason", e.LastError)
+}
+
+func (s *Spool) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Spool) remove(e *Entry) {
+	// Hold the lock so a concurrent scan cannot load the file again
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, e.ID)
+	if err := os.Remove(entryPath(s.cfg.Dir, queueDir, e.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.logger.Error("failed to remove spool file", "id", e.ID, "error", err)
+	}
+}
+
+func entryPath(dir, sub, id string) string {
+	return filepath.Join(dir, sub, id+".json")
+}
+
+// writeEntry stores e atomically: the file is written and synced under tmp/
+// and then renamed into sub, whose directory is synced so the rename
+// survives a crash.
+func writeEntry(dir, sub string, e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Join(dir, tmpDir), e.ID+"-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, entryPath(dir, sub, e.ID)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Join(dir, sub))
+}
+
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func readEntry(path string) (*Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, err
+	}
+	if e.ID == "" || e.Message == nil {
+		return nil, errors.New("missing id or message")
+	}
+	return &e, nil
+}
+
+// newID returns a sortable, unique spool ID.
+func newID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(b)), nil
+}
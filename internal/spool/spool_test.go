@@ -0,0 +1,250 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alex/smtp-gotify/internal/mail"
+)
+
+type flakyForwarder struct {
+	mu        sync.Mutex
+	failures  int
+	calls     int
+	delivered chan *mail.Message
+}
+
+func (f *flakyForwarder) Forward(ctx context.Context, msg *mail.Message) error {
+	f.mu.Lock()
+	f.calls++
+	fail := f.calls <= f.failures
+	f.mu.Unlock()
+
+	if fail {
+		return errors.New("gotify unavailable")
+	}
+	f.delivered <- msg
+	return nil
+}
+
+func newTestSpool(t *testing.T, dir string, fwd Forwarder) *Spool {
+	t.Helper()
+	s, err := New(Config{
+		Dir:        dir,
+		Forwarder:  fwd,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+		Logger:     slog.Default(),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return s
+}
+
+func queued(t *testing.T, dir string) int {
+	t.Helper()
+	files, err := os.ReadDir(filepath.Join(dir, queueDir))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return len(files)
+}
+
+func TestSpool_DeliverWithRetry(t *testing.T) {
+	dir := t.TempDir()
+	fwd := &flakyForwarder{failures: 2, delivered: make(chan *mail.Message, 1)}
+	s := newTestSpool(t, dir, fwd)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	if err := s.Forward(context.Background(), &mail.Message{Subject: "Hello"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case msg := <-fwd.delivered:
+		if msg.Subject != "Hello" {
+			t.Errorf("expected subject Hello, got %s", msg.Subject)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message was not delivered")
+	}
+
+	// The file is removed right after a successful delivery
+	deadline := time.Now().Add(time.Second)
+	for queued(t, dir) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := queued(t, dir); n != 0 {
+		t.Errorf("expected empty queue, got %d files", n)
+	}
+	fwd.mu.Lock()
+	if fwd.calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", fwd.calls)
+	}
+	fwd.mu.Unlock()
+}
+
+func TestSpool_SurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	// Spool without a running worker, as if the process stopped right away
+	s := newTestSpool(t, dir, nil)
+	if err := s.Forward(context.Background(), &mail.Message{Subject: "Persisted", Recipients: []string{"a@example.com"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := queued(t, dir); n != 1 {
+		t.Fatalf("expected 1 queued file, got %d", n)
+	}
+
+	fwd := &flakyForwarder{delivered: make(chan *mail.Message, 1)}
+	s = newTestSpool(t, dir, fwd)
+	if s.Len() != 1 {
+		t.Fatalf("expected 1 loaded message, got %d", s.Len())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	select {
+	case msg := <-fwd.delivered:
+		if msg.Subject != "Persisted" || len(msg.Recipients) != 1 {
+			t.Errorf("unexpected message after restart: %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message was not delivered after restart")
+	}
+}
+
+func TestSpool_MaxAttempts(t *testing.T) {
+	dir := t.TempDir()
+	fwd := &flakyForwarder{failures: 100, delivered: make(chan *mail.Message, 1)}
+	s := newTestSpool(t, dir, fwd)
+	s.cfg.MaxAttempts = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	if err := s.Forward(context.Background(), &mail.Message{Subject: "Doomed"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for s.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if s.Len() != 0 {
+		t.Fatal("expected message to leave the queue after max attempts")
+	}
+	fwd.mu.Lock()
+	if fwd.calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", fwd.calls)
+	}
+	fwd.mu.Unlock()
+
+	dead, err := s.DeadLetters().List()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dead) != 1 || dead[0].Message.Subject != "Doomed" || dead[0].LastError != "gotify unavailable" {
+		t.Fatalf("expected message in dead-letter queue with reason, got %+v", dead)
+	}
+}
+
+func TestSpool_Backoff(t *testing.T) {
+	s := &Spool{cfg: Config{MinBackoff: time.Second, MaxBackoff: 10 * time.Second}}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := s.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// blockingForwarder holds every delivery until release is closed.
+type blockingForwarder struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (f *blockingForwarder) Forward(ctx context.Context, msg *mail.Message) error {
+	f.started <- struct{}{}
+	<-f.release
+	return nil
+}
+
+func TestSpool_Workers(t *testing.T) {
+	dir := t.TempDir()
+	fwd := &blockingForwarder{started: make(chan struct{}, 3), release: make(chan struct{})}
+	s, err := New(Config{
+		Dir:        dir,
+		Forwarder:  fwd,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+		Workers:    2,
+		Logger:     slog.Default(),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for range 3 {
+		if err := s.Forward(context.Background(), &mail.Message{Subject: "Hello"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	for range 2 {
+		select {
+		case <-fwd.started:
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected two deliveries to run concurrently")
+		}
+	}
+	select {
+	case <-fwd.started:
+		t.Fatal("expected at most two deliveries in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(fwd.release)
+	deadline := time.Now().Add(2 * time.Second)
+	for s.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.Len() != 0 || queued(t, dir) != 0 {
+		t.Errorf("expected all messages to be delivered, %d left", s.Len())
+	}
+
+	cancel()
+	<-done
+}
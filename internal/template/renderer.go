@@ -2,16 +2,60 @@ package template
 
 import (
 	"bytes"
+	"encoding/json"
+	"net/textproto"
+	"regexp"
+	"strings"
 	"text/template"
 
 	"github.com/alex/smtp-gotify/internal/mail"
 )
 
 type TemplateData struct {
-	From    string
-	To      string
-	Subject string
-	Body    string
+	ID         string
+	From       string
+	To         string
+	Subject    string
+	Body       string
+	User       string
+	RemoteAddr string
+
+	headers map[string]string
+}
+
+// Header returns the first value of the named message header, e.g.
+// {{.Header "X-Alert-ID"}}.
+func (d TemplateData) Header(name string) string {
+	return d.headers[textproto.CanonicalMIMEHeaderKey(name)]
+}
+
+var funcs = template.FuncMap{
+	"match": match,
+	"json":  toJSON,
+}
+
+// toJSON encodes v as a JSON value, e.g. {"text": {{json .Subject}}}.
+func toJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// match returns the first capture group of pattern in s, or the whole match
+// if the pattern has no groups, e.g. {{match `\[(\w+)\]` .Subject}}.
+func match(pattern, s string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	m := re.FindStringSubmatch(s)
+	switch {
+	case m == nil:
+		return "", nil
+	case len(m) > 1:
+		return m[1], nil
+	default:
+		return m[0], nil
+	}
 }
 
 type Renderer struct {
@@ -20,12 +64,12 @@ type Renderer struct {
 }
 
 func NewRenderer(titleTemplate, messageTemplate string) (*Renderer, error) {
-	titleTpl, err := template.New("title").Parse(titleTemplate)
+	titleTpl, err := template.New("title").Funcs(funcs).Parse(titleTemplate)
 	if err != nil {
 		return nil, err
 	}
 
-	messageTpl, err := template.New("message").Parse(messageTemplate)
+	messageTpl, err := template.New("message").Funcs(funcs).Parse(messageTemplate)
 	if err != nil {
 		return nil, err
 	}
@@ -37,12 +81,7 @@ func NewRenderer(titleTemplate, messageTemplate string) (*Renderer, error) {
 }
 
 func (r *Renderer) Render(msg *mail.Message) (title, message string, err error) {
-	data := TemplateData{
-		From:    msg.From,
-		To:      joinAddresses(msg.To),
-		Subject: msg.Subject,
-		Body:    msg.Body,
-	}
+	data := newData(msg)
 
 	var titleBuf bytes.Buffer
 	if err := r.titleTpl.Execute(&titleBuf, data); err != nil {
@@ -57,6 +96,60 @@ func (r *Renderer) Render(msg *mail.Message) (title, message string, err error)
 	return titleBuf.String(), msgBuf.String(), nil
 }
 
+// Text renders a single template from a message, such as a webhook body.
+type Text struct {
+	tpl *template.Template
+}
+
+func NewText(name, text string) (*Text, error) {
+	tpl, err := template.New(name).Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Text{tpl: tpl}, nil
+}
+
+func (t *Text) Render(msg *mail.Message) (string, error) {
+	var buf bytes.Buffer
+	if err := t.tpl.Execute(&buf, newData(msg)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Key renders a single line identifying a message, such as the alert a
+// monitoring mail is about.
+type Key struct {
+	text *Text
+}
+
+func NewKey(text string) (*Key, error) {
+	t, err := NewText("key", text)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{text: t}, nil
+}
+
+// Render returns the key for msg with surrounding whitespace removed.
+func (k *Key) Render(msg *mail.Message) (string, error) {
+	s, err := k.text.Render(msg)
+	return strings.TrimSpace(s), err
+}
+
+func newData(msg *mail.Message) TemplateData {
+	return TemplateData{
+		ID:         msg.ID,
+		From:       msg.From,
+		To:         joinAddresses(msg.To),
+		Subject:    msg.Subject,
+		Body:       msg.Body,
+		User:       msg.User,
+		RemoteAddr: msg.RemoteAddr,
+		headers:    msg.Headers,
+	}
+}
+
 func joinAddresses(addrs []string) string {
 	if len(addrs) == 0 {
 		return ""
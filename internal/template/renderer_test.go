@@ -55,9 +55,76 @@ func TestRenderer_RenderMultipleRecipients(t *testing.T) {
 	}
 }
 
+func TestRenderer_RenderSessionFields(t *testing.T) {
+	r, err := NewRenderer("{{.Subject}}", "{{.User}} via {{.RemoteAddr}}")
+	if err != nil {
+		t.Fatalf("failed to create renderer: %v", err)
+	}
+
+	msg := &mail.Message{User: "printer", RemoteAddr: "203.0.113.7"}
+
+	_, body, err := r.Render(msg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "printer via 203.0.113.7"
+	if body != expected {
+		t.Errorf("expected %q, got %q", expected, body)
+	}
+}
+
 func TestNewRenderer_InvalidTemplate(t *testing.T) {
 	_, err := NewRenderer("{{.Invalid", "valid")
 	if err == nil {
 		t.Error("expected error for invalid template")
 	}
 }
+
+func TestKey_Render(t *testing.T) {
+	msg := &mail.Message{
+		Subject: "[RESOLVED] HighCPU (web-1)",
+		Headers: map[string]string{"X-Alert-Id": "cpu-42"},
+	}
+
+	tests := []struct {
+		tpl  string
+		want string
+	}{
+		{`{{.Header "X-Alert-ID"}}`, "cpu-42"},
+		{`{{match "\\] (.+)$" .Subject}}`, "HighCPU (web-1)"},
+		{`{{match "HighCPU" .Subject}}`, "HighCPU"},
+		{`{{match "DiskFull" .Subject}}`, ""},
+		{` {{.Header "X-Missing"}} `, ""},
+	}
+	for _, tt := range tests {
+		k, err := NewKey(tt.tpl)
+		if err != nil {
+			t.Fatalf("NewKey(%q): %v", tt.tpl, err)
+		}
+		got, err := k.Render(msg)
+		if err != nil {
+			t.Fatalf("Render(%q): %v", tt.tpl, err)
+		}
+		if got != tt.want {
+			t.Errorf("Render(%q) = %q, want %q", tt.tpl, got, tt.want)
+		}
+	}
+}
+
+func TestText_RenderJSON(t *testing.T) {
+	tpl, err := NewText("body", `{"subject": {{json .Subject}}, "body": {{json .Body}}}`)
+	if err != nil {
+		t.Fatalf("failed to create template: %v", err)
+	}
+
+	got, err := tpl.Render(&mail.Message{Subject: `Disk "sda" failing`, Body: "line 1\nline 2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := `{"subject": "Disk \"sda\" failing", "body": "line 1\nline 2"}`
+	if got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,139 @@
+package webhook
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alex/smtp-gotify/internal/delivery"
+	"github.com/alex/smtp-gotify/internal/mail"
+	"github.com/alex/smtp-gotify/internal/template"
+)
+
+// DefaultBody is the JSON body sent when no template is configured.
+const DefaultBody = `{"id": {{json .ID}}, "from": {{json .From}}, "to": {{json .To}}, "subject": {{json .Subject}}, "body": {{json .Body}}}`
+
+// Client sends each message as a JSON request to an arbitrary URL.
+type Client struct {
+	url    string
+	method string
+	header http.Header
+	body   *template.Text
+	// dest identifies the webhook in a message's Delivered list
+	dest   string
+	sent   *delivery.SentCache
+	http   *http.Client
+	logger *slog.Logger
+}
+
+type Config struct {
+	URL string
+	// Method is POST, PUT or PATCH, POST if empty.
+	Method string
+	// Header is added to every request. Content-Type defaults to
+	// application/json.
+	Header http.Header
+	// BearerToken, or Username and Password, set the Authorization header.
+	BearerToken string
+	Username    string
+	Password    string
+	// Body renders the JSON request body, DefaultBody if nil.
+	Body *template.Text
+	// Timeout bounds a single request, 0 means 30s.
+	Timeout   time.Duration
+	Transport http.RoundTripper
+	Logger    *slog.Logger
+}
+
+func NewClient(cfg Config) (*Client, error) {
+	method := strings.ToUpper(cfg.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	body := cfg.Body
+	if body == nil {
+		var err error
+		if body, err = template.NewText("body", DefaultBody); err != nil {
+			return nil, err
+		}
+	}
+
+	header := cfg.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/json")
+	}
+	switch {
+	case cfg.BearerToken != "":
+		header.Set("Authorization", "Bearer "+cfg.BearerToken)
+	case cfg.Username != "":
+		creds := base64.StdEncoding.EncodeToString([]byte(cfg.Username + ":" + cfg.Password))
+		header.Set("Authorization", "Basic "+creds)
+	}
+
+	sum := sha256.Sum256([]byte(method + " " + cfg.URL))
+	return &Client{
+		url:    cfg.URL,
+		method: method,
+		header: header,
+		body:   body,
+		dest:   "webhook:" + hex.EncodeToString(sum[:8]),
+		sent:   delivery.NewSentCache(delivery.SentTTL),
+		http: &http.Client{
+			Timeout:   timeout,
+			Transport: cfg.Transport,
+		},
+		logger: cfg.Logger,
+	}, nil
+}
+
+func (c *Client) Forward(ctx context.Context, msg *mail.Message) error {
+	if slices.Contains(msg.Delivered, c.dest) || c.sent.Has(msg, c.dest) {
+		c.logger.Debug("skipping webhook already delivered", "id", msg.ID)
+		return nil
+	}
+
+	body, err := c.body.Render(msg)
+	if err != nil {
+		return &delivery.TemplateError{Err: fmt.Errorf("body: %w", err)}
+	}
+	if !json.Valid([]byte(body)) {
+		return &delivery.TemplateError{Err: errors.New("body is not valid JSON")}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, c.method, c.url, strings.NewReader(body))
+	if err != nil {
+		return delivery.Redact(err)
+	}
+	req.Header = c.header.Clone()
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return &delivery.RequestError{Err: delivery.Redact(err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return delivery.NewStatusError(resp)
+	}
+
+	c.logger.Debug("message sent to webhook", "id", msg.ID, "status", resp.Status)
+	msg.Delivered = append(msg.Delivered, c.dest)
+	c.sent.Add(msg, c.dest)
+	return nil
+}